@@ -0,0 +1,346 @@
+package oauth2kit
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// AuthRequest is an authorization request built by AuthCodeURL.
+type AuthRequest struct {
+	// URL is the authorization URL to send the user to.
+	URL string
+
+	// State is the value the provider returns with the callback. Check it
+	// against the callback's "state" parameter.
+	State string
+
+	// Verifier is the PKCE verifier to pass to Exchange. It is a secret:
+	// keep it server-side and never send it to the browser.
+	Verifier string
+
+	// Expiry is when URL stops being accepted by the provider, for requests
+	// pushed to Config.PAREndpoint. It is zero otherwise.
+	Expiry time.Time
+}
+
+// AuthCodeURL builds an authorization request for Config.Scopes without
+// running the flow, for applications handling the callback themselves,
+// possibly in another process. Keep State and Verifier until the callback
+// arrives, then pass the code to Exchange.
+//
+// AuthCodeURL never pushes the request to Config.PAREndpoint, which takes a
+// network round trip; use AuthCodeURLContext then. It fails if the PKCE
+// verifier cannot be generated, see Config.PKCEVerifierLength.
+func (m *Manager) AuthCodeURL() (*AuthRequest, error) {
+	verifier, err := m.generateVerifier()
+	if err != nil {
+		return nil, err
+	}
+	req := &AuthRequest{
+		State:    rand.Text(),
+		Verifier: verifier,
+	}
+	req.URL, _ = m.unpushedAuthCodeURL(context.Background(), m.oauth2ConfigOAuth2(), req.State, req.Verifier)
+	m.putState(req)
+	return req, nil
+}
+
+// AuthCodeURLContext is like AuthCodeURL, but pushes the request to
+// Config.PAREndpoint first if set (RFC 9126), as required by FAPI
+// compliant providers.
+func (m *Manager) AuthCodeURLContext(ctx context.Context) (*AuthRequest, error) {
+	verifier, err := m.generateVerifier()
+	if err != nil {
+		return nil, err
+	}
+	req := &AuthRequest{
+		State:    rand.Text(),
+		Verifier: verifier,
+	}
+	req.URL, req.Expiry, err = m.authCodeURL(ctx, m.oauth2ConfigOAuth2(), req.State, req.Verifier)
+	if err != nil {
+		return nil, err
+	}
+	m.putState(req)
+	return req, nil
+}
+
+// BuildAuthURL returns the authorization URL for Config.Scopes with the
+// given state and PKCE verifier, for documentation and tooling. Empty ones
+// are rendered as the placeholders "STATE" and "CODE_CHALLENGE". Unlike
+// AuthCodeURL, it has no side effects: the state is not registered, and the
+// request is never pushed to Config.PAREndpoint.
+func (m *Manager) BuildAuthURL(state, verifier string) (string, error) {
+	if m.Config.Endpoint.AuthURL == "" {
+		return "", errors.New("Endpoint.AuthURL is empty")
+	}
+	opts := m.authCodeOptions(verifier)
+	if verifier == "" && m.Config.ResponseType != ResponseTypeToken {
+		opts = append(opts, oauth2.SetAuthURLParam("code_challenge", "CODE_CHALLENGE"))
+	}
+	return m.oauth2ConfigOAuth2().AuthCodeURL(cmp.Or(state, "STATE"), opts...), nil
+}
+
+func (m *Manager) putState(req *AuthRequest) {
+	m.stateStore().Put(req.State, req.Verifier, m.now().Add(stateTTL))
+}
+
+// stateStore returns Config.StateStore, or the Manager's own store, which
+// follows Config.Clock.
+func (m *Manager) stateStore() StateStore {
+	if m.Config.StateStore != nil {
+		return m.Config.StateStore
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.states.Clock == nil {
+		m.states.Clock = m.now
+	}
+	return &m.states
+}
+
+// ExchangeCallback completes a request built by AuthCodeURL from the
+// callback's "code" and "state" parameters, looking the PKCE verifier up in
+// Config.StateStore. It returns ErrInvalidState if the state is unknown or
+// has expired, e.g. when the callback was forged or replayed.
+func (m *Manager) ExchangeCallback(ctx context.Context, code, state string) (*oauth2.Token, error) {
+	verifier, ok := m.stateStore().Get(state)
+	if !ok {
+		return nil, ErrInvalidState
+	}
+	return m.Exchange(ctx, code, verifier)
+}
+
+// ExchangeCode exchanges an authorization code obtained out of band, e.g.
+// in end-to-end tests or custom UI flows, for the request built by
+// AuthCodeURL with state and verifier, and persists the token. No browser
+// is opened nor server started.
+//
+// State must be a pending request issued with verifier, kept in
+// Config.StateStore or by the Manager itself, or ErrInvalidState is
+// returned. Each request can be completed once.
+func (m *Manager) ExchangeCode(ctx context.Context, code, verifier, state string) (*oauth2.Token, error) {
+	stored, ok := m.stateStore().Get(state)
+	if !ok || stored != verifier {
+		return nil, ErrInvalidState
+	}
+	return m.Exchange(ctx, code, verifier)
+}
+
+// Exchange exchanges the authorization code received by the callback of a
+// request built by AuthCodeURL, using the request's PKCE verifier. The
+// token is persisted before being returned.
+func (m *Manager) Exchange(ctx context.Context, code, verifier string) (*oauth2.Token, error) {
+	token, err := m.exchange(ctx, m.oauth2ConfigOAuth2(), code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("token exchange: %w", asTokenError(err))
+	}
+	if token, err = m.acceptToken(ctx, token, m.Config.Scopes); err != nil {
+		return nil, err
+	}
+	if err := m.saveToken(ctx, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// ----------------------------------------------------------------------------
+// State storage
+// ----------------------------------------------------------------------------
+
+// stateTTL bounds how long an authorization request built by AuthCodeURL
+// can be completed.
+const stateTTL = 10 * time.Minute
+
+// StateStore keeps the PKCE verifier of pending authorization requests,
+// keyed by state, between the redirect to the provider and the callback.
+// Web applications implement it on top of their session store (e.g. Redis)
+// so that the callback can be handled by any instance.
+type StateStore interface {
+	// Put stores the verifier of state until exp.
+	Put(state, verifier string, exp time.Time)
+
+	// Get returns the verifier of state, unless it is unknown or expired.
+	// Each state should be returned only once, to prevent replays.
+	Get(state string) (verifier string, ok bool)
+}
+
+// MemoryStateStore is a StateStore keeping states in memory, suitable for
+// applications running a single instance.
+type MemoryStateStore struct {
+	// Clock returns the current time states expire against, e.g.
+	// Config.Clock. If nil, time.Now is used.
+	Clock func() time.Time
+
+	mu     sync.Mutex
+	states map[string]storedState
+}
+
+type storedState struct {
+	verifier string
+	exp      time.Time
+}
+
+func (s *MemoryStateStore) Put(state, verifier string, exp time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.states == nil {
+		s.states = make(map[string]storedState)
+	}
+	// Drop expired states so that abandoned requests do not accumulate
+	now := s.now()
+	for k, v := range s.states {
+		if now.After(v.exp) {
+			delete(s.states, k)
+		}
+	}
+	s.states[state] = storedState{verifier: verifier, exp: exp}
+}
+
+func (s *MemoryStateStore) now() time.Time {
+	if s.Clock != nil {
+		return s.Clock()
+	}
+	return time.Now()
+}
+
+func (s *MemoryStateStore) Get(state string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.states[state]
+	if !ok {
+		return "", false
+	}
+	delete(s.states, state)
+	if s.now().After(v.exp) {
+		return "", false
+	}
+	return v.verifier, true
+}
+
+// ----------------------------------------------------------------------------
+// Helper functions
+// ----------------------------------------------------------------------------
+
+// The bounds of the PKCE verifier length (RFC 7636, section 4.1).
+const (
+	minVerifierLength = 43
+	maxVerifierLength = 128
+)
+
+// generateVerifier returns a new PKCE verifier of Config.PKCEVerifierLength
+// characters read from Config.Rand, or oauth2.GenerateVerifier's if
+// neither is set.
+func (m *Manager) generateVerifier() (string, error) {
+	cfg := m.Config
+	if cfg.PKCEVerifierLength == 0 && cfg.Rand == nil {
+		return oauth2.GenerateVerifier(), nil
+	}
+	n := cmp.Or(cfg.PKCEVerifierLength, minVerifierLength)
+	if n < minVerifierLength || n > maxVerifierLength {
+		return "", fmt.Errorf("PKCEVerifierLength %d is out of the %d-%d range", n, minVerifierLength, maxVerifierLength)
+	}
+	// Base64url characters are all allowed in verifiers (RFC 7636,
+	// section 4.1)
+	b := make([]byte, base64.RawURLEncoding.DecodedLen(n)+1)
+	if _, err := io.ReadFull(cmp.Or[io.Reader](cfg.Rand, rand.Reader), b); err != nil {
+		return "", fmt.Errorf("generate PKCE verifier: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)[:n], nil
+}
+
+// authCodeURL builds the authorization URL of conf for state, pushing the
+// request to Config.PAREndpoint if set. It returns the expiry of pushed
+// requests.
+func (m *Manager) authCodeURL(ctx context.Context, conf *oauth2.Config, state, verifier string) (string, time.Time, error) {
+	authURL, opts := m.unpushedAuthCodeURL(ctx, conf, state, verifier)
+	if m.Config.PAREndpoint == "" {
+		return authURL, time.Time{}, nil
+	}
+	return m.pushAuthRequest(ctx, conf, state, opts)
+}
+
+// unpushedAuthCodeURL builds the authorization URL of conf for state
+// carrying all the request parameters, and logs the request. It returns
+// the options the URL was built with.
+func (m *Manager) unpushedAuthCodeURL(ctx context.Context, conf *oauth2.Config, state, verifier string) (string, []oauth2.AuthCodeOption) {
+	opts := m.authCodeOptions(verifier)
+	if hint := m.storedLoginHint(ctx); hint != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("login_hint", hint))
+	}
+	authURL := conf.AuthCodeURL(state, opts...)
+	m.logAuthRequest(ctx, authURL)
+	return authURL, opts
+}
+
+// storedLoginHint returns the email address, or else the subject, of the
+// ID token of the stored token, for Config.UseLoginHintFromToken.
+func (m *Manager) storedLoginHint(ctx context.Context) string {
+	if !m.Config.UseLoginHintFromToken || m.Config.LoginHint != "" {
+		return ""
+	}
+	token, err := m.tokenStore().Load(ctx)
+	if err != nil {
+		return ""
+	}
+	claims, err := idTokenClaims(token)
+	if err != nil {
+		return ""
+	}
+	if email, _ := claims["email"].(string); email != "" {
+		return email
+	}
+	sub, _ := claims["sub"].(string)
+	return sub
+}
+
+// logAuthRequest records what an authorization request asks for, as an
+// audit trail.
+func (m *Manager) logAuthRequest(ctx context.Context, authURL string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return
+	}
+	q := u.Query()
+	attrs := []any{
+		slog.String("client_id", q.Get("client_id")),
+		slog.Any("scopes", strings.Fields(q.Get("scope"))),
+		slog.String("redirect_uri", q.Get("redirect_uri")),
+	}
+	for _, key := range []string{"audience", "resource"} {
+		if q.Has(key) {
+			attrs = append(attrs, slog.Any(key, q[key]))
+		}
+	}
+	m.logger(ctx).Info("Authorization request", attrs...)
+}
+
+// authCodeOptions returns the options of an authorization request, with
+// PKCE parameters derived from verifier. The implicit grant has no code to
+// exchange, so PKCE does not apply to it.
+func (m *Manager) authCodeOptions(verifier string) []oauth2.AuthCodeOption {
+	opts := m.Config.authCodeOptions()
+	if m.Config.ResponseType == ResponseTypeToken {
+		opts = append(opts, oauth2.SetAuthURLParam("response_type", ResponseTypeToken))
+	} else {
+		opts = append(opts, oauth2.S256ChallengeOption(verifier))
+	}
+	if m.Config.DPoP {
+		if key, err := m.dpopKey(); err == nil {
+			opts = append(opts, oauth2.SetAuthURLParam("dpop_jkt", dpopThumbprint(key)))
+		}
+	}
+	return opts
+}
@@ -0,0 +1,112 @@
+package oauth2kit
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestAuthCodeURLInvalidVerifierLength(t *testing.T) {
+	m := &Manager{Config: Config{
+		ClientID:           "client",
+		PKCEVerifierLength: 10,
+		StateStore:         &MemoryStateStore{},
+	}}
+	m.Config.Endpoint.AuthURL = "https://provider.example/auth"
+	if req, err := m.AuthCodeURL(); err == nil {
+		t.Fatalf("AuthCodeURL = %+v, want an error", req)
+	}
+
+	m.Config.PKCEVerifierLength = 64
+	req, err := m.AuthCodeURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifier, ok := m.Config.StateStore.Get(req.State); !ok || verifier != req.Verifier || len(verifier) != 64 {
+		t.Errorf("stored verifier %q, %v, want %q", verifier, ok, req.Verifier)
+	}
+}
+
+func TestExchangeCodeChecksState(t *testing.T) {
+	p := newFakeProvider(t)
+	m := p.manager(t)
+	ctx := context.Background()
+	req, err := m.AuthCodeURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.ExchangeCode(ctx, "code", req.Verifier, "forged"); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("unknown state: got %v, want ErrInvalidState", err)
+	}
+	if n := p.calls(); n != 0 {
+		t.Errorf("%d token requests for an unknown state, want none", n)
+	}
+	if _, err := m.ExchangeCode(ctx, "code", req.Verifier, req.State); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.form(0).Get("code_verifier"); got != req.Verifier {
+		t.Errorf("code_verifier %q, want %q", got, req.Verifier)
+	}
+	if _, err := m.ExchangeCode(ctx, "code", req.Verifier, req.State); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("replayed state: got %v, want ErrInvalidState", err)
+	}
+}
+
+func TestMemoryStateStoreFollowsClock(t *testing.T) {
+	clock := newFakeClock()
+	m := &Manager{Config: Config{Clock: clock.Now}}
+	m.Config.Endpoint.AuthURL = "https://provider.example/auth"
+	req, err := m.AuthCodeURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(stateTTL + time.Second)
+	if _, ok := m.stateStore().Get(req.State); ok {
+		t.Error("state still pending after stateTTL by Clock")
+	}
+}
+
+// fixedLoggerRepository hands out the same logger whatever the context.
+type fixedLoggerRepository struct{ logger *slog.Logger }
+
+func (r fixedLoggerRepository) LoggerFromContext(context.Context) *slog.Logger { return r.logger }
+
+func (r fixedLoggerRepository) ContextWithLogger(ctx context.Context, _ *slog.Logger) context.Context {
+	return ctx
+}
+
+func TestAuthCodeURLLogsAndHints(t *testing.T) {
+	p := newFakeProvider(t)
+	m := p.manager(t)
+	m.Config.UseLoginHintFromToken = true
+	var buf bytes.Buffer
+	m.LoggerRepository = fixedLoggerRepository{slog.New(slog.NewTextHandler(&buf, nil))}
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"email":"user@example.com"}`))
+	storeToken(t, m, (&oauth2.Token{AccessToken: "access"}).WithExtra(map[string]any{
+		"id_token": "eyJhbGciOiJub25lIn0." + payload + ".sig",
+	}))
+
+	req, err := m.AuthCodeURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Query().Get("login_hint"); got != "user@example.com" {
+		t.Errorf("login_hint %q, want the stored token's email", got)
+	}
+	if !strings.Contains(buf.String(), "Authorization request") {
+		t.Errorf("authorization request not logged: %q", buf.String())
+	}
+}
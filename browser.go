@@ -0,0 +1,108 @@
+package oauth2kit
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/micheam/go-oauth2kit/qrcode"
+)
+
+// BrowserOpener opens a URL in the user's browser.
+type BrowserOpener interface {
+	Open(url string) error
+}
+
+// BrowserOpenerFunc adapts an ordinary function to a BrowserOpener.
+// BrowserOpenerFunc(func(string) error { return nil }) disables launching
+// a browser, e.g. in tests.
+type BrowserOpenerFunc func(url string) error
+
+func (f BrowserOpenerFunc) Open(url string) error {
+	return f(url)
+}
+
+// SystemBrowserOpener opens URLs with the operating system's default
+// browser. It is used when Config.BrowserOpener is nil.
+type SystemBrowserOpener struct {
+	// Env holds "KEY=value" entries added to the environment of the
+	// launched command, e.g. "DISPLAY=:0" or "BROWSER=firefox".
+	Env []string
+}
+
+func (o SystemBrowserOpener) Open(url string) error {
+	return openURL(url, o.Env)
+}
+
+func (m *Manager) browserOpener() BrowserOpener {
+	if m.Config.BrowserOpener != nil {
+		return m.Config.BrowserOpener
+	}
+	return SystemBrowserOpener{Env: m.Config.BrowserEnv}
+}
+
+// PrintAuthQR writes url as a QR code to the Manager's writer, for users
+// completing the authorization on a phone.
+func (m *Manager) PrintAuthQR(url string) error {
+	code, err := qrcode.Encode(url)
+	if err != nil {
+		return err
+	}
+	return code.WriteTerminal(m.GetWriter())
+}
+
+// ----------------------------------------------------------------------------
+// Helper functions
+// ----------------------------------------------------------------------------
+
+// procVersionFile is read to detect WSL. It is a variable so that the
+// detection can be exercised on any system.
+var procVersionFile = "/proc/version"
+
+func openURL(url string, env []string) error {
+	switch os := runtime.GOOS; os {
+	case "windows":
+		return command(env, "rundll32", "url.dll,FileProtocolHandler", url).Start()
+	case "darwin":
+		return command(env, "open", url).Start()
+	case "linux":
+		if isWSL() {
+			return openURLFromWSL(url, env)
+		}
+		return command(env, "xdg-open", url).Start()
+	default: // "freebsd", "openbsd", "netbsd"
+		return command(env, "xdg-open", url).Start()
+	}
+}
+
+// isWSL reports whether we are running under the Windows Subsystem for
+// Linux, whose kernel version mentions Microsoft.
+func isWSL() bool {
+	b, err := os.ReadFile(procVersionFile)
+	if err != nil {
+		return false
+	}
+	return strings.Contains(strings.ToLower(string(b)), "microsoft")
+}
+
+// openURLFromWSL opens url with the Windows browser, preferring wslview
+// (from wslu) and falling back to PowerShell through WSL interop.
+func openURLFromWSL(url string, env []string) error {
+	if path, err := exec.LookPath("wslview"); err == nil {
+		return command(env, path, url).Start()
+	}
+	// Single-quoted PowerShell strings only need quotes doubled
+	quoted := "'" + strings.ReplaceAll(url, "'", "''") + "'"
+	return command(env, "powershell.exe", "-NoProfile", "-NonInteractive", "-Command", "Start-Process "+quoted).Start()
+}
+
+// command returns a command running name with env added to the current
+// environment.
+func command(env []string, name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd
+}
@@ -0,0 +1,85 @@
+package oauth2kit
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeProcVersion points procVersionFile to a file holding version.
+func fakeProcVersion(t *testing.T, version string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "version")
+	if err := os.WriteFile(path, []byte(version), 0600); err != nil {
+		t.Fatal(err)
+	}
+	saved := procVersionFile
+	procVersionFile = path
+	t.Cleanup(func() { procVersionFile = saved })
+}
+
+func TestIsWSL(t *testing.T) {
+	for version, want := range map[string]bool{
+		"Linux version 5.15.167.4-microsoft-standard-WSL2 (root@f9c826d3017f)": true,
+		"Linux version 4.4.0-19041-Microsoft (Microsoft@Microsoft.com)":        true,
+		"Linux version 6.8.0-45-generic (buildd@lcy02-amd64-115)":              false,
+	} {
+		fakeProcVersion(t, version)
+		if got := isWSL(); got != want {
+			t.Errorf("isWSL() = %v for %q, want %v", got, version, want)
+		}
+	}
+
+	procVersionFile = filepath.Join(t.TempDir(), "missing")
+	if isWSL() {
+		t.Error("isWSL() = true without a version file")
+	}
+}
+
+func TestOpenURLFromWSL(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("WSL is only detected on Linux")
+	}
+	fakeProcVersion(t, "Linux version 5.15.167.4-microsoft-standard-WSL2")
+	if _, err := exec.LookPath("wslview"); err == nil {
+		t.Skip("wslview is installed")
+	}
+	const url = "https://provider.example/auth?state=it's"
+
+	for _, tool := range []string{"wslview", "powershell.exe"} {
+		t.Run(tool, func(t *testing.T) {
+			// Fake the tool, recording its arguments
+			bin := t.TempDir()
+			out := filepath.Join(bin, "args")
+			script := "#!/bin/sh\nprintf '%s\\n' \"$@\" > " + out + ".tmp && mv " + out + ".tmp " + out + "\n"
+			if err := os.WriteFile(filepath.Join(bin, tool), []byte(script), 0700); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+			if err := openURL(url, nil); err != nil {
+				t.Fatal(err)
+			}
+			var args []byte
+			for deadline := time.Now().Add(5 * time.Second); ; {
+				var err error
+				if args, err = os.ReadFile(out); err == nil || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			got := strings.TrimSpace(string(args))
+			want := url
+			if tool == "powershell.exe" {
+				want = "-NoProfile\n-NonInteractive\n-Command\nStart-Process 'https://provider.example/auth?state=it''s'"
+			}
+			if got != want {
+				t.Errorf("%s run with %q, want %q", tool, got, want)
+			}
+		})
+	}
+}
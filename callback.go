@@ -0,0 +1,580 @@
+package oauth2kit
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// callbackTimeout bounds how long a flow waits for the user to complete the
+// authorization in the browser.
+const callbackTimeout = 5 * time.Minute
+
+// ServerTimeouts holds the timeouts of the local callback server. They keep
+// stray local connections from holding the server open during the
+// authorization window.
+type ServerTimeouts struct {
+	// ReadHeader is the time allowed to read request headers.
+	// Default: 10s
+	ReadHeader time.Duration
+
+	// Read is the time allowed to read an entire request.
+	// Default: 30s
+	Read time.Duration
+
+	// Write is the time allowed to write a response.
+	// Default: 30s
+	Write time.Duration
+
+	// Idle is the time a keep-alive connection may stay idle.
+	// Default: 60s
+	Idle time.Duration
+}
+
+// newCallbackServer returns the local callback server serving handler.
+func (c *Config) newCallbackServer(addr string, handler http.Handler) *http.Server {
+	t := c.ServerTimeouts
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cmp.Or(t.ReadHeader, 10*time.Second),
+		ReadTimeout:       cmp.Or(t.Read, 30*time.Second),
+		WriteTimeout:      cmp.Or(t.Write, 30*time.Second),
+		IdleTimeout:       cmp.Or(t.Idle, 60*time.Second),
+	}
+}
+
+// pendingFlow is an authorization request waiting for the provider to
+// redirect the browser back to the callback handler.
+type pendingFlow struct {
+	state    string
+	verifier string
+	implicit bool
+	logger   *slog.Logger
+
+	// redirectURL is the redirect URI sent with the authorization request.
+	redirectURL string
+
+	// result receives the first callback for this flow. It is buffered, so
+	// that delivering never blocks the handler, even once the flow is over.
+	result    chan callbackResult
+	delivered sync.Once
+}
+
+// callbackResult is what the callback handler delivers to a pending flow.
+type callbackResult struct {
+	code  string
+	token *oauth2.Token // implicit grant only
+	err   error
+
+	// mismatch is set when the callback did not arrive at the configured
+	// redirect URI, which usually makes the exchange fail.
+	mismatch string
+}
+
+// deliver hands res to the flow. It reports false if a result was already
+// delivered, e.g. when the browser retries the redirect.
+func (f *pendingFlow) deliver(res callbackResult) bool {
+	first := false
+	f.delivered.Do(func() {
+		f.result <- res
+		first = true
+	})
+	return first
+}
+
+// CallbackHandler returns the handler receiving the provider's redirect.
+//
+// Applications with their own HTTP server mount it at Config.ServerPath and
+// call WaitForToken to run the flow. GetToken serves it on a temporary local
+// server instead.
+//
+// The handler accepts any path ending with Config.ServerPath, reading the
+// code and state from the query. Behind a reverse proxy adding a prefix
+// (e.g. "/app/callback"), mount it on the prefixed path or on a catch-all
+// pattern and keep ServerPath to the final segments.
+func (m *Manager) CallbackHandler() http.Handler {
+	return http.HandlerFunc(m.handleCallback)
+}
+
+// WaitForToken runs the authorization flow against the handler returned by
+// CallbackHandler, which must already be served by the caller. It opens the
+// authorization URL, blocks until the handler receives the callback, then
+// exchanges the authorization code and persists the token.
+func (m *Manager) WaitForToken(ctx context.Context) (*oauth2.Token, error) {
+	token, err := m.runFlow(ctx, m.Config.Scopes, m.Config.buildRedirectURL(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.saveToken(ctx, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// authorize runs the interactive authorization flow for scopes on a
+// temporary local server listening on Config.LocalAddr.
+func (m *Manager) authorize(ctx context.Context, scopes []string) (*oauth2.Token, error) {
+	logger := m.logger(ctx)
+	cfg := m.Config
+	if cfg.CodeReader != nil {
+		return m.readCodeFlow(ctx, scopes)
+	}
+
+	handler, err := cfg.callbackRoutes(m.CallbackHandler())
+	if err != nil {
+		return nil, err
+	}
+
+	// The listener is bound before the browser is opened, so that the
+	// redirect cannot arrive before the server accepts connections;
+	// connections made before Serve runs wait in the accept queue.
+	ln, redirectURL, err := cfg.listenCallback()
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.RedirectURLs) > 0 && cfg.RedirectURL == "" {
+		logger.Info("Using redirect URL", slog.String("redirect_uri", redirectURL))
+	}
+	logger.Debug("Callback server listening", slog.String("addr", ln.Addr().String()))
+	m.record(EventServerStarted, "Listening on "+ln.Addr().String(), nil)
+
+	// Start local server to receive callback
+	server := cfg.newCallbackServer(ln.Addr().String(), handler)
+	serveErr := make(chan error, 1)
+	go func() {
+		if err := server.Serve(ln); err != http.ErrServerClosed {
+			serveErr <- err
+		}
+	}()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Error("Server shutdown error", slog.Any("error", err))
+		}
+	}()
+
+	return m.runFlow(ctx, scopes, redirectURL, serveErr)
+}
+
+// callbackRoutes returns the handler of the local callback server: the
+// requests matching Config.CallbackRoutes are served by their handler, all
+// others by callback, which filters paths itself so that prefixes added by
+// proxies are tolerated. It fails if a pattern is invalid, conflicts with
+// another one, or matches the callback path.
+func (c *Config) callbackRoutes(callback http.Handler) (http.Handler, error) {
+	if len(c.CallbackRoutes) == 0 {
+		return callback, nil
+	}
+	routes := http.NewServeMux()
+	for _, pattern := range slices.Sorted(maps.Keys(c.CallbackRoutes)) {
+		if err := handleRoute(routes, pattern, c.CallbackRoutes[pattern]); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://localhost"+c.serverPath(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("callback path: %w", err)
+	}
+	if _, pattern := routes.Handler(req); pattern != "" {
+		return nil, fmt.Errorf("CallbackRoutes pattern %q matches the callback path %s", pattern, c.serverPath())
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := routes.Handler(r); pattern != "" {
+			routes.ServeHTTP(w, r)
+			return
+		}
+		callback.ServeHTTP(w, r)
+	}), nil
+}
+
+// handleRoute registers handler for pattern on mux, returning the error
+// http.ServeMux panics with for invalid or conflicting patterns.
+func handleRoute(mux *http.ServeMux, pattern string, handler http.Handler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("CallbackRoutes pattern %q: %v", pattern, r)
+		}
+	}()
+	mux.Handle(pattern, handler)
+	return nil
+}
+
+// listenCallback binds the local callback server. With Config.RedirectURLs,
+// the port of each URL is tried in order and the first one that can be
+// bound is used, unless Config.LocalAddr is a Unix socket or
+// Config.RedirectURL is set. It returns the listener and the matching
+// redirect URI.
+func (c *Config) listenCallback() (net.Listener, string, error) {
+	if path, ok := c.unixSocket(); ok {
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, "", fmt.Errorf("callback server: %w", err)
+		}
+		return ln, c.buildRedirectURL(), nil
+	}
+	if len(c.RedirectURLs) == 0 || c.RedirectURL != "" {
+		localAddr := defaultLocalAddr
+		if addr := c.LocalAddr; addr != "" {
+			localAddr = addr
+		}
+		ln, err := net.Listen("tcp", localAddr)
+		if err != nil {
+			return nil, "", fmt.Errorf("callback server: %w", err)
+		}
+		// Port 0 picks an ephemeral port, which loopback redirect URIs may
+		// use (RFC 8252, section 7.3)
+		if _, port, _ := net.SplitHostPort(localAddr); port == "0" && c.RedirectURL == "" {
+			return ln, fmt.Sprintf("http://localhost:%d%s", ln.Addr().(*net.TCPAddr).Port, c.serverPath()), nil
+		}
+		return ln, c.buildRedirectURL(), nil
+	}
+
+	var errs []error
+	for _, redirectURL := range c.RedirectURLs {
+		u, err := url.Parse(redirectURL)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("parse redirect URL: %w", err))
+			continue
+		}
+		ln, err := net.Listen("tcp", ":"+cmp.Or(u.Port(), "80"))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return ln, redirectURL, nil
+	}
+	return nil, "", fmt.Errorf("callback server: no redirect URL available: %w", errors.Join(errs...))
+}
+
+// runFlow registers a pending flow, sends the user to the authorization URL
+// requesting scopes with redirectURL and waits for the callback. serveErr reports failures
+// of the server hosting the callback handler, if the Manager owns it.
+func (m *Manager) runFlow(ctx context.Context, scopes []string, redirectURL string, serveErr <-chan error) (_ *oauth2.Token, err error) {
+	defer func() {
+		if err != nil {
+			m.record(EventFlowFailed, "Authorization failed", err)
+		}
+	}()
+	logger := m.logger(ctx)
+	cfg := m.Config
+	conf := m.oauth2ConfigOAuth2()
+	conf.Scopes = scopes
+	conf.RedirectURL = redirectURL
+
+	verifier, err := m.generateVerifier()
+	if err != nil {
+		return nil, err
+	}
+	flow := &pendingFlow{
+		state:       rand.Text(),
+		verifier:    verifier,
+		implicit:    cfg.ResponseType == ResponseTypeToken,
+		logger:      logger,
+		redirectURL: redirectURL,
+		result:      make(chan callbackResult, 1),
+	}
+	m.addFlow(flow)
+	defer m.removeFlow(flow.state)
+
+	if flow.implicit {
+		if err := cfg.checkImplicit(); err != nil {
+			return nil, err
+		}
+		logger.Warn("Using the implicit grant (ResponseType \"token\"); this flow is less secure than the authorization code flow")
+	}
+	authURL, parExpiry, err := m.authCodeURL(ctx, conf, flow.state, flow.verifier)
+	if err != nil {
+		return nil, err
+	}
+	if !parExpiry.IsZero() {
+		logger.Debug("Authorization request pushed", slog.Time("expiry", parExpiry))
+	}
+	logger.Debug("Authorization URL", slog.String("url", redactURL(authURL)))
+
+	if cfg.CopyToClipboard {
+		if err := copyToClipboard(authURL); err != nil {
+			logger.Warn("Failed to copy URL to clipboard", slog.Any("error", err))
+			fmt.Fprintf(m.GetWriter(), "Please open the following URL in your browser:\n%s\n", authURL)
+		} else {
+			fmt.Fprintln(m.GetWriter(), "URL copied to clipboard, paste it in your browser to authenticate")
+		}
+	} else {
+		// Open browser to authorization URL
+		m.inform(cmp.Or(cfg.OpeningBrowserMessage, "Opening browser for authentication..."))
+		if err := m.browserOpener().Open(authURL); err != nil {
+			logger.Warn("Failed to open browser", slog.Any("error", err))
+			m.record(EventBrowserOpened, "Failed to open browser", err)
+			fmt.Fprintf(m.GetWriter(), "Please open the following URL in your browser:\n%s\n", authURL)
+		} else {
+			m.record(EventBrowserOpened, "Browser opened", nil)
+		}
+	}
+
+	if cfg.ShowQRCode {
+		fmt.Fprintln(m.GetWriter(), "Or scan this QR code to authenticate on another device:")
+		if err := m.PrintAuthQR(authURL); err != nil {
+			logger.Warn("Failed to print QR code", slog.Any("error", err))
+		}
+	}
+
+	// Wait for authorization code
+	var res callbackResult
+	select {
+	case res = <-flow.result:
+	case err := <-serveErr:
+		return nil, fmt.Errorf("callback server: %w", err)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(callbackTimeout):
+		return nil, errors.New("timeout waiting for authorization code")
+	}
+	if res.err != nil {
+		return nil, res.err
+	}
+	logger.Debug("Callback received")
+	m.record(EventCallbackReceived, "Callback received", nil)
+
+	if flow.implicit {
+		m.inform("\n✓ Access token received")
+		return m.acceptToken(ctx, res.token, scopes)
+	}
+	m.inform("\n✓ Authorization code received")
+
+	// Exchange authorization code for token with PKCE verifier
+	m.inform("Exchanging authorization code for token...")
+	token, err := m.exchange(ctx, conf, res.code, oauth2.VerifierOption(flow.verifier))
+	if err != nil {
+		err = asTokenError(err)
+		if res.mismatch != "" {
+			return nil, fmt.Errorf("token exchange (%s; check the redirect URI registered with the provider): %w", res.mismatch, err)
+		}
+		return nil, fmt.Errorf("token exchange: %w", err)
+	}
+	logger.Debug("Authorization code exchanged")
+	m.record(EventCodeExchanged, "Authorization code exchanged", nil)
+	return m.acceptToken(ctx, token, scopes)
+}
+
+func (m *Manager) addFlow(flow *pendingFlow) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.flows == nil {
+		m.flows = make(map[string]*pendingFlow)
+	}
+	m.flows[flow.state] = flow
+}
+
+func (m *Manager) removeFlow(state string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.flows, state)
+}
+
+func (m *Manager) lookupFlow(state string) *pendingFlow {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.flows[state]
+}
+
+func (m *Manager) handleCallback(w http.ResponseWriter, r *http.Request) {
+	setCallbackHeaders(w.Header())
+	// Browsers ask for an icon alongside the callback page
+	if r.URL.Path == "/favicon.ico" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !m.Config.isCallbackPath(r.URL.Path) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "Not Found")
+		return
+	}
+
+	// The implicit grant returns the token in the URL fragment, which only
+	// the page served here can relay back.
+	if m.Config.ResponseType == ResponseTypeToken && r.Method != http.MethodPost {
+		fmt.Fprint(w, implicitCallbackHTML)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Error: Invalid callback request")
+		return
+	}
+
+	flow := m.lookupFlow(r.Form.Get("state"))
+	if flow == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Error: Unknown or expired authorization request")
+		return
+	}
+
+	var res callbackResult
+	// Proxies in front of Config.RedirectURL rewrite the host
+	if mismatch := redirectMismatch(r, flow.redirectURL); mismatch != "" && m.Config.RedirectURL == "" {
+		flow.logger.Warn("Redirect URI mismatch; check the redirect URI registered with the provider",
+			slog.String("detail", mismatch))
+		res.mismatch = mismatch
+	}
+	if e := r.Form.Get("error"); e != "" {
+		flow.deliver(callbackResult{err: fmt.Errorf("authorization failed: %s %s", e, r.Form.Get("error_description"))})
+		fmt.Fprintf(w, "Error: %s", html.EscapeString(e))
+		return
+	}
+
+	if flow.implicit {
+		token, err := tokenFromFragment(r.PostForm, m.now())
+		if err != nil {
+			flow.deliver(callbackResult{err: err})
+			fmt.Fprint(w, "Error: No access token received")
+			return
+		}
+		res.token = token
+	} else {
+		res.code = r.Form.Get("code")
+		if res.code == "" {
+			flow.deliver(callbackResult{err: errors.New("no authorization code received")})
+			fmt.Fprint(w, "Error: No authorization code received")
+			return
+		}
+	}
+
+	// The result is delivered before responding, so that the exchange does
+	// not delay the redirect. Only the first callback of a flow is acted
+	// upon; duplicates, e.g. from prefetchers, get a page of their own.
+	if !flow.deliver(res) {
+		fmt.Fprint(w, alreadyReceivedHTML)
+		return
+	}
+	if m.Config.SuccessRedirectURL != "" {
+		http.Redirect(w, r, m.Config.SuccessRedirectURL, http.StatusFound)
+		return
+	}
+	fmt.Fprint(w, m.Config.successHTML())
+}
+
+// ----------------------------------------------------------------------------
+// Helper functions
+// ----------------------------------------------------------------------------
+
+// setCallbackHeaders sets the headers shared by every response of the local
+// callback server. The pages are rendered once and thrown away, so they must
+// never be cached nor sniffed as anything other than HTML.
+func setCallbackHeaders(h http.Header) {
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Content-Type-Options", "nosniff")
+}
+
+const successHTML = `<html>
+  <body>
+	<h1>Authentication Successful!</h1>
+	<p>You can close this window and return to the terminal.</p>
+  </body>
+  </html>`
+
+const autoCloseSuccessHTML = `<html>
+  <body>
+	<h1>Authentication Successful!</h1>
+	<p>This window will close automatically. If it does not, you can close it and return to the terminal.</p>
+	<script>setTimeout(function () { window.close(); }, 1000);</script>
+  </body>
+  </html>`
+
+const alreadyReceivedHTML = `<html>
+  <body>
+	<h1>Authorization Already Received</h1>
+	<p>This authorization request has already been completed. You can close this window.</p>
+  </body>
+  </html>`
+
+func (c *Config) successHTML() string {
+	if c.SuccessHTML != "" {
+		return c.SuccessHTML
+	}
+	if c.AutoCloseTab {
+		return autoCloseSuccessHTML
+	}
+	return successHTML
+}
+
+// redirectMismatch describes how the URL a callback request arrived at
+// differs from the configured redirect URI, ignoring the query. It returns
+// an empty string when they match.
+func redirectMismatch(r *http.Request, redirectURL string) string {
+	expected, err := url.Parse(redirectURL)
+	if err != nil {
+		return ""
+	}
+	expected.RawQuery = ""
+	got := &url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path}
+	if r.TLS != nil {
+		got.Scheme = "https"
+	}
+	// A path prefix may have been added by a proxy; see CallbackHandler
+	if got.Scheme == expected.Scheme && strings.EqualFold(got.Host, expected.Host) && strings.HasSuffix(got.Path, expected.Path) {
+		return ""
+	}
+	return fmt.Sprintf("callback received at %s, but the configured redirect URI is %s", got, expected)
+}
+
+// implicitCallbackHTML is served when the provider redirects back with the
+// token in the URL fragment. Browsers never send the fragment to the server,
+// so the page posts it back to the same path as a form.
+const implicitCallbackHTML = `<html>
+  <body>
+	<h1 id="status">Completing authentication...</h1>
+	<script>
+	  var params = new URLSearchParams(window.location.hash.substring(1));
+	  fetch(window.location.pathname, { method: "POST", body: params })
+		.then(function (resp) {
+		  if (resp.redirected) {
+			window.location.replace(resp.url);
+			return;
+		  }
+		  return resp.text().then(function (html) { document.body.innerHTML = html; });
+		})
+		.catch(function (err) {
+		  document.getElementById("status").textContent = "Error: " + err;
+		});
+	</script>
+  </body>
+  </html>`
+
+// tokenFromFragment builds a token from the parameters of an implicit grant
+// response (RFC 6749, section 4.2.2) received at now.
+func tokenFromFragment(v url.Values, now time.Time) (*oauth2.Token, error) {
+	accessToken := v.Get("access_token")
+	if accessToken == "" {
+		return nil, errors.New("no access token received")
+	}
+	token := &oauth2.Token{
+		AccessToken: accessToken,
+		TokenType:   v.Get("token_type"),
+	}
+	if s := v.Get("expires_in"); s != "" {
+		expiresIn, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse expires_in: %w", err)
+		}
+		token.ExpiresIn = expiresIn
+		token.Expiry = now.Add(time.Duration(expiresIn) * time.Second)
+	}
+	return token.WithExtra(map[string]any{"scope": v.Get("scope")}), nil
+}
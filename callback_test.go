@@ -0,0 +1,188 @@
+package oauth2kit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCallbackDeliveredOnce(t *testing.T) {
+	m := &Manager{}
+	flow := &pendingFlow{
+		state:       "state",
+		logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		redirectURL: "http://localhost:15440/callback",
+		result:      make(chan callbackResult, 1),
+	}
+	m.addFlow(flow)
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 2)
+	for i := range bodies {
+		wg.Go(func() {
+			req := httptest.NewRequest("GET", "http://localhost:15440/callback?code=code&state=state", nil)
+			rec := httptest.NewRecorder()
+			m.handleCallback(rec, req)
+			bodies[i] = rec.Body.String()
+		})
+	}
+	wg.Wait()
+
+	if res := <-flow.result; res.code != "code" {
+		t.Errorf("delivered code %q, want %q", res.code, "code")
+	}
+	select {
+	case res := <-flow.result:
+		t.Errorf("second delivery: %+v", res)
+	default:
+	}
+	var already int
+	for _, body := range bodies {
+		if strings.Contains(body, "Authorization Already Received") {
+			already++
+		}
+	}
+	if already != 1 {
+		t.Errorf("%d responses are the already received page, want 1: %q", already, bodies)
+	}
+}
+
+func TestCallbackRoutes(t *testing.T) {
+	logo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "logo")
+	})
+	for _, routes := range []map[string]http.Handler{
+		{"/": logo},
+		{"/callback": logo},
+		{"GET /{path...}": logo},
+		{"/logo.png": logo, "GET /logo.png": logo, "/{name}.png": logo},
+		{"/{name": logo},
+	} {
+		cfg := Config{CallbackRoutes: routes}
+		if _, err := cfg.callbackRoutes(logo); err == nil {
+			t.Errorf("routes %v accepted", slices.Collect(maps.Keys(routes)))
+		}
+	}
+
+	m := &Manager{}
+	cfg := Config{CallbackRoutes: map[string]http.Handler{"/logo.png": logo}}
+	handler, err := cfg.callbackRoutes(m.CallbackHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]int{
+		"/logo.png":                   http.StatusOK,
+		"/callback?state=unknown":     http.StatusBadRequest,
+		"/proxy/callback?state=other": http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost:15440"+path, nil))
+		if rec.Code != want {
+			t.Errorf("GET %s: status %d, want %d", path, rec.Code, want)
+		}
+	}
+}
+
+func TestImplicitGrantRefusesClaimChecks(t *testing.T) {
+	p := newFakeProvider(t)
+	m := p.manager(t)
+	signIn(t, m, "code")
+	m.Config.ResponseType = ResponseTypeToken
+	m.Config.ExpectedIssuer = "https://issuer.example"
+	opened := false
+	m.Config.BrowserOpener = BrowserOpenerFunc(func(string) error {
+		opened = true
+		return nil
+	})
+	if _, err := m.authorize(context.Background(), m.Config.Scopes); err == nil {
+		t.Fatal("implicit grant with ExpectedIssuer accepted")
+	}
+	if opened {
+		t.Error("browser opened")
+	}
+}
+
+func TestProxiedRedirectURL(t *testing.T) {
+	const redirectURL = "https://Proxy.Example:8443/oauth/callback?tenant=a%2Fb"
+	p := newFakeProvider(t)
+	m := p.manager(t)
+	m.Config.CodeReader = nil
+	m.Config.RedirectURL = redirectURL
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	m.Config.LocalAddr = fmt.Sprintf(":%d", port)
+
+	var authorized string
+	m.Config.BrowserOpener = BrowserOpenerFunc(func(authURL string) error {
+		u, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+		authorized = u.Query().Get("redirect_uri")
+		// The proxy forwards the callback with its own host
+		callback := fmt.Sprintf("http://127.0.0.1:%d/oauth/callback?tenant=a%%2Fb&code=code&state=%s", port, u.Query().Get("state"))
+		req, err := http.NewRequest(http.MethodGet, callback, nil)
+		if err != nil {
+			return err
+		}
+		req.Host = "Proxy.Example:8443"
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	})
+
+	if _, err := m.GetToken(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if authorized != redirectURL {
+		t.Errorf("authorization redirect_uri %q, want %q", authorized, redirectURL)
+	}
+	if got := p.form(0).Get("redirect_uri"); got != redirectURL {
+		t.Errorf("exchange redirect_uri %q, want %q", got, redirectURL)
+	}
+}
+
+func TestCallbackServerListensBeforeBrowserOpens(t *testing.T) {
+	p := newFakeProvider(t)
+	m := p.manager(t)
+	signIn(t, m, "code")
+	open := m.Config.BrowserOpener
+	m.Config.BrowserOpener = BrowserOpenerFunc(func(authURL string) error {
+		u, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+		redirect, err := url.Parse(u.Query().Get("redirect_uri"))
+		if err != nil {
+			return err
+		}
+		conn, err := net.Dial("tcp", redirect.Host)
+		if err != nil {
+			t.Errorf("callback server not listening when the browser opens: %v", err)
+			return err
+		}
+		conn.Close()
+		return open.Open(authURL)
+	})
+	for range 10 {
+		if _, err := m.authorize(context.Background(), m.Config.Scopes); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
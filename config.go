@@ -0,0 +1,204 @@
+package oauth2kit
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// defaultClientSecretEnv is the environment variable LoadConfig reads the
+// client secret from, unless FileConfig.ClientSecretEnv names another one.
+const defaultClientSecretEnv = "OAUTH2KIT_CLIENT_SECRET"
+
+// FileConfig is the JSON schema read by LoadConfig. It holds the settings
+// of Config that can be shared safely; the client secret is read from the
+// environment instead. For example:
+//
+//	{
+//	  "client_id": "1234.apps.googleusercontent.com",
+//	  "scopes": ["openid", "email"],
+//	  "auth_url": "https://accounts.google.com/o/oauth2/auth",
+//	  "token_url": "https://oauth2.googleapis.com/token",
+//	  "local_addr": ":15440",
+//	  "token_file": "/var/lib/app/token.json"
+//	}
+type FileConfig struct {
+	ClientID string   `json:"client_id"`
+	Scopes   []string `json:"scopes,omitempty"`
+
+	// ClientSecretEnv names the environment variable holding the client
+	// secret. Default: "OAUTH2KIT_CLIENT_SECRET"
+	ClientSecretEnv string `json:"client_secret_env,omitempty"`
+
+	// Endpoints of the provider. AuthStyle is "header" or "params";
+	// the style is auto-detected if empty.
+	AuthURL          string `json:"auth_url"`
+	TokenURL         string `json:"token_url"`
+	DeviceAuthURL    string `json:"device_auth_url,omitempty"`
+	AuthStyle        string `json:"auth_style,omitempty"`
+	RevocationURL    string `json:"revocation_url,omitempty"`
+	UserInfoURL      string `json:"userinfo_url,omitempty"`
+	IntrospectionURL string `json:"introspection_url,omitempty"`
+	JWKSURL          string `json:"jwks_url,omitempty"`
+	PAREndpoint      string `json:"par_endpoint,omitempty"`
+
+	// Local callback server
+	LocalAddr    string   `json:"local_addr,omitempty"`
+	ServerPath   string   `json:"server_path,omitempty"`
+	RedirectURLs []string `json:"redirect_urls,omitempty"`
+	RedirectURL  string   `json:"redirect_url,omitempty"`
+
+	// Token persistence
+	TokenFile       string `json:"token_file,omitempty"`
+	NamespaceTokens bool   `json:"namespace_tokens,omitempty"`
+
+	PublicClient bool `json:"public_client,omitempty"`
+}
+
+// LoadConfig reads a Config from the JSON file at path, following the
+// schema of FileConfig, and takes the client secret from the environment
+// variable named by its "client_secret_env" field. Unknown fields are
+// rejected, so that typos do not go unnoticed.
+func LoadConfig(path string) (Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("read config: %w", err)
+	}
+	var fc FileConfig
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&fc); err != nil {
+		return Config{}, fmt.Errorf("decode config %s: %w", path, err)
+	}
+	return fc.Config()
+}
+
+// Config returns the Config described by fc, with the client secret read
+// from the environment.
+func (fc *FileConfig) Config() (Config, error) {
+	var style oauth2.AuthStyle
+	switch fc.AuthStyle {
+	case "":
+		style = oauth2.AuthStyleAutoDetect
+	case "header":
+		style = oauth2.AuthStyleInHeader
+	case "params":
+		style = oauth2.AuthStyleInParams
+	default:
+		return Config{}, fmt.Errorf("invalid auth_style %q: want \"header\" or \"params\"", fc.AuthStyle)
+	}
+	return Config{
+		ClientID:     fc.ClientID,
+		ClientSecret: os.Getenv(cmp.Or(fc.ClientSecretEnv, defaultClientSecretEnv)),
+		Scopes:       fc.Scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:       fc.AuthURL,
+			TokenURL:      fc.TokenURL,
+			DeviceAuthURL: fc.DeviceAuthURL,
+			AuthStyle:     style,
+		},
+		RevocationURL:    fc.RevocationURL,
+		UserInfoURL:      fc.UserInfoURL,
+		IntrospectionURL: fc.IntrospectionURL,
+		JWKSURL:          fc.JWKSURL,
+		PAREndpoint:      fc.PAREndpoint,
+		LocalAddr:        fc.LocalAddr,
+		ServerPath:       fc.ServerPath,
+		RedirectURLs:     fc.RedirectURLs,
+		RedirectURL:      fc.RedirectURL,
+		TokenFile:        fc.TokenFile,
+		NamespaceTokens:  fc.NamespaceTokens,
+		PublicClient:     fc.PublicClient,
+	}, nil
+}
+
+// envPrefix prefixes the environment variables read by Config.ApplyEnv.
+const envPrefix = "OAUTH2KIT_"
+
+// ConfigFromEnv returns a Config built from the environment variables read
+// by Config.ApplyEnv.
+func ConfigFromEnv() (Config, error) {
+	var c Config
+	err := c.ApplyEnv()
+	return c, err
+}
+
+// ApplyEnv sets the fields of c left empty from environment variables, so
+// that operators can adjust a deployment without code changes. Fields set
+// explicitly take precedence over the environment, which takes precedence
+// over the defaults.
+//
+// The variables are named after the fields: OAUTH2KIT_CLIENT_ID,
+// OAUTH2KIT_CLIENT_SECRET, OAUTH2KIT_SCOPES, OAUTH2KIT_AUTH_URL,
+// OAUTH2KIT_TOKEN_URL, OAUTH2KIT_DEVICE_AUTH_URL, OAUTH2KIT_SERVER_PATH,
+// OAUTH2KIT_LOCAL_ADDR, OAUTH2KIT_REDIRECT_URLS, OAUTH2KIT_REDIRECT_URL,
+// OAUTH2KIT_ACCESS_TYPE, OAUTH2KIT_PROMPT, OAUTH2KIT_LOGIN_HINT,
+// OAUTH2KIT_REVOCATION_URL, OAUTH2KIT_USERINFO_URL,
+// OAUTH2KIT_INTROSPECTION_URL, OAUTH2KIT_JWKS_URL, OAUTH2KIT_PAR_ENDPOINT,
+// OAUTH2KIT_TOKEN_FILE, OAUTH2KIT_PUBLIC_CLIENT, OAUTH2KIT_NAMESPACE_TOKENS,
+// OAUTH2KIT_DEBUG and OAUTH2KIT_QUIET.
+// List values are separated by spaces or commas; booleans are parsed with
+// strconv.ParseBool.
+func (c *Config) ApplyEnv() error {
+	for name, field := range map[string]*string{
+		"CLIENT_ID":         &c.ClientID,
+		"CLIENT_SECRET":     &c.ClientSecret,
+		"AUTH_URL":          &c.Endpoint.AuthURL,
+		"TOKEN_URL":         &c.Endpoint.TokenURL,
+		"DEVICE_AUTH_URL":   &c.Endpoint.DeviceAuthURL,
+		"SERVER_PATH":       &c.ServerPath,
+		"LOCAL_ADDR":        &c.LocalAddr,
+		"REDIRECT_URL":      &c.RedirectURL,
+		"ACCESS_TYPE":       &c.AccessType,
+		"PROMPT":            &c.Prompt,
+		"LOGIN_HINT":        &c.LoginHint,
+		"REVOCATION_URL":    &c.RevocationURL,
+		"USERINFO_URL":      &c.UserInfoURL,
+		"INTROSPECTION_URL": &c.IntrospectionURL,
+		"JWKS_URL":          &c.JWKSURL,
+		"PAR_ENDPOINT":      &c.PAREndpoint,
+		"TOKEN_FILE":        &c.TokenFile,
+	} {
+		if *field == "" {
+			*field = os.Getenv(envPrefix + name)
+		}
+	}
+
+	for name, field := range map[string]*[]string{
+		"SCOPES":        &c.Scopes,
+		"REDIRECT_URLS": &c.RedirectURLs,
+	} {
+		if len(*field) == 0 {
+			*field = strings.FieldsFunc(os.Getenv(envPrefix+name), func(r rune) bool {
+				return r == ' ' || r == ','
+			})
+		}
+	}
+
+	var errs []error
+	for name, field := range map[string]*bool{
+		"PUBLIC_CLIENT":    &c.PublicClient,
+		"NAMESPACE_TOKENS": &c.NamespaceTokens,
+		"DEBUG":            &c.Debug,
+		"QUIET":            &c.Quiet,
+	} {
+		v := os.Getenv(envPrefix + name)
+		if *field || v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("$%s%s: %w", envPrefix, name, err))
+			continue
+		}
+		*field = b
+	}
+	return errors.Join(errs...)
+}
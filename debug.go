@@ -0,0 +1,150 @@
+package oauth2kit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// debugHandler lowers the level of the handler it wraps to debug, for
+// Config.Debug: debug records are handed to it even though its own level
+// would discard them, so that they keep its destination and format.
+type debugHandler struct {
+	slog.Handler
+}
+
+func (h debugHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelDebug || h.Handler.Enabled(ctx, level)
+}
+
+func (h debugHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return debugHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h debugHandler) WithGroup(name string) slog.Handler {
+	return debugHandler{h.Handler.WithGroup(name)}
+}
+
+// debugTransport logs the requests made to the provider with Config.Debug.
+type debugTransport struct {
+	m    *Manager
+	base http.RoundTripper
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	logger := t.m.logger(req.Context())
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	// The query is left out: it may carry codes or tokens
+	target := req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+	if err != nil {
+		logger.Debug("HTTP request failed", slog.String("method", req.Method), slog.String("url", target),
+			slog.Duration("elapsed", elapsed), slog.Any("error", err))
+		return nil, err
+	}
+	logger.Debug("HTTP request", slog.String("method", req.Method), slog.String("url", target),
+		slog.Int("status", resp.StatusCode), slog.Duration("elapsed", elapsed))
+	return resp, nil
+}
+
+// redactURL returns rawURL with the values of parameters that must not
+// appear in logs replaced.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "(invalid URL)"
+	}
+	q := u.Query()
+	for _, key := range []string{"state", "code_challenge", "login_hint"} {
+		if q.Has(key) {
+			q.Set(key, "REDACTED")
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// secretFields lists the request and response fields redacted from the
+// traces of Config.TraceHTTP.
+var secretFields = []string{
+	"client_secret", "client_assertion", "code", "code_verifier", "device_code",
+	"password", "assertion", "token", "access_token", "refresh_token", "id_token",
+}
+
+// traceTransport logs the requests made to the provider's endpoints and
+// their responses, with secrets redacted, for Config.TraceHTTP.
+type traceTransport struct {
+	m    *Manager
+	base http.RoundTripper
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.m.isEndpointRequest(req) {
+		return t.base.RoundTrip(req)
+	}
+	logger := t.m.logger(req.Context())
+	target := req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+
+	var reqBody string
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			b, _ := io.ReadAll(io.LimitReader(body, 64<<10))
+			body.Close()
+			reqBody = redactForm(string(b))
+		}
+	}
+	logger.Info("HTTP request", slog.String("method", req.Method), slog.String("url", target), slog.String("body", reqBody))
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		logger.Info("HTTP response", slog.String("method", req.Method), slog.String("url", target), slog.Any("error", err))
+		return nil, err
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	logger.Info("HTTP response", slog.String("method", req.Method), slog.String("url", target),
+		slog.Int("status", resp.StatusCode), slog.String("body", redactJSON(b)))
+	return resp, nil
+}
+
+// redactForm returns the form-encoded body with its secret values
+// replaced.
+func redactForm(body string) string {
+	v, err := url.ParseQuery(body)
+	if err != nil {
+		return "(unparsable body)"
+	}
+	for _, key := range secretFields {
+		if v.Has(key) {
+			v.Set(key, "REDACTED")
+		}
+	}
+	return v.Encode()
+}
+
+// redactJSON returns the JSON body with its secret values replaced. Bodies
+// which are not JSON objects are left out.
+func redactJSON(body []byte) string {
+	var v map[string]any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return fmt.Sprintf("(%d bytes)", len(body))
+	}
+	for _, key := range secretFields {
+		if _, ok := v[key]; ok {
+			v[key] = "REDACTED"
+		}
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,34 @@
+package oauth2kit
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestDebugKeepsLoggerHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})).With(slog.String("app", "test"))
+	ctx := WithLogger(context.Background(), logger)
+
+	m := &Manager{}
+	m.logger(ctx).Debug("hidden")
+	if buf.Len() != 0 {
+		t.Fatalf("debug record logged without Debug: %s", buf.String())
+	}
+
+	m.Config.Debug = true
+	m.logger(ctx).Debug("shown", slog.Int("n", 1))
+	m.logger(ctx).Info("info")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("logged %q, want two records", lines)
+	}
+	for _, want := range []string{`"level":"DEBUG"`, `"msg":"shown"`, `"n":1`, `"app":"test"`} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("debug record %s lacks %s", lines[0], want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package oauth2kit
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// defaultDevicePollInterval is the polling interval used when the
+	// provider does not return one (RFC 8628, section 3.2).
+	defaultDevicePollInterval = 5 * time.Second
+)
+
+// DeviceAuthResponse is the provider's response to a device authorization
+// request (RFC 8628, section 3.2). UIs present UserCode and VerificationURI
+// to the user, or VerificationURIComplete (which embeds the user code) as a
+// link or QR code when the provider returns it.
+type DeviceAuthResponse struct {
+	// DeviceCode is the code identifying the device; it is not shown.
+	DeviceCode string
+
+	// UserCode is the code the user enters at VerificationURI.
+	UserCode string
+
+	// VerificationURI is where the user enters UserCode.
+	VerificationURI string
+
+	// VerificationURIComplete, if returned, is VerificationURI with
+	// UserCode embedded.
+	VerificationURIComplete string
+
+	// ExpiresIn is the lifetime of the device and user codes.
+	ExpiresIn time.Duration
+
+	// Interval is the minimum time to wait between polling requests.
+	Interval time.Duration
+
+	// Expiry is when the device and user codes expire.
+	Expiry time.Time
+}
+
+// StartDeviceFlow starts the device authorization grant (RFC 8628) for
+// Config.Scopes. It requires Config.Endpoint.DeviceAuthURL. Present the
+// response to the user, then call PollDeviceToken.
+func (m *Manager) StartDeviceFlow(ctx context.Context) (*DeviceAuthResponse, error) {
+	da, err := m.oauth2ConfigOAuth2().DeviceAuth(m.oauth2Context(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("device authorization: %w", asTokenError(err))
+	}
+	resp := &DeviceAuthResponse{
+		DeviceCode:              da.DeviceCode,
+		UserCode:                da.UserCode,
+		VerificationURI:         da.VerificationURI,
+		VerificationURIComplete: da.VerificationURIComplete,
+		Interval:                time.Duration(da.Interval) * time.Second,
+		Expiry:                  da.Expiry,
+	}
+	if !da.Expiry.IsZero() {
+		// x/oauth2 counts the expiry from time.Now; recover the lifetime
+		// it was given and count it from Config.Clock instead
+		resp.ExpiresIn = time.Until(da.Expiry).Round(time.Second)
+		resp.Expiry = m.now().Add(resp.ExpiresIn)
+	}
+	return resp, nil
+}
+
+// DeviceFlow runs the device authorization grant for Config.Scopes from
+// start to end: it displays the user code and verification URI with
+// Config.DeviceCodeFormatter, then polls until the user completes the
+// authorization. The token is persisted before being returned.
+func (m *Manager) DeviceFlow(ctx context.Context) (*oauth2.Token, error) {
+	resp, err := m.StartDeviceFlow(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.logger(ctx).Info("Waiting for device authorization",
+		slog.String("user_code", resp.UserCode), slog.String("verification_uri", resp.VerificationURI))
+
+	format := m.Config.DeviceCodeFormatter
+	if format == nil {
+		format = formatDeviceCode
+	}
+	fmt.Fprint(m.GetWriter(), format(resp.UserCode, resp.VerificationURI))
+	if m.Config.ShowQRCode {
+		uri := cmp.Or(resp.VerificationURIComplete, resp.VerificationURI)
+		if err := m.PrintAuthQR(uri); err != nil {
+			m.logger(ctx).Warn("Failed to print QR code", slog.Any("error", err))
+		}
+	}
+	return m.PollDeviceToken(ctx, resp)
+}
+
+// PollDeviceToken polls the token endpoint until the user completes the
+// authorization started by StartDeviceFlow, the codes expire or ctx is
+// done. The token is persisted before being returned.
+//
+// Requests are spaced by the interval returned by the provider, at least
+// Config.DevicePollInterval, plus a small random jitter so that many
+// clients do not poll in lockstep. The interval grows by 5 seconds each
+// time the provider answers "slow_down" (RFC 8628, section 3.5).
+func (m *Manager) PollDeviceToken(ctx context.Context, resp *DeviceAuthResponse) (*oauth2.Token, error) {
+	interval := max(resp.Interval, m.Config.DevicePollInterval)
+	if interval <= 0 {
+		interval = defaultDevicePollInterval
+	}
+	params := url.Values{
+		"grant_type":  {deviceCodeGrantType},
+		"device_code": {resp.DeviceCode},
+	}
+
+	var (
+		token    *oauth2.Token
+		failures int           // consecutive failed requests
+		wait     time.Duration // before the next request, after a failure
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval + wait + rand.N(interval/10+1)):
+		}
+		if !resp.Expiry.IsZero() && m.now().After(resp.Expiry) {
+			return nil, errors.New("device access token: device code expired")
+		}
+
+		var err error
+		token, err = m.retrieveToken(m.oauth2Context(ctx), params)
+		if err == nil {
+			break
+		}
+		var re *oauth2.RetrieveError
+		errors.As(err, &re)
+		switch {
+		case re != nil && re.ErrorCode == "authorization_pending":
+			failures, wait = 0, 0
+			continue
+		case re != nil && re.ErrorCode == "slow_down":
+			failures, wait = 0, 0
+			interval += 5 * time.Second
+			m.logger(ctx).Debug("Device flow polling slowed down", slog.Duration("interval", interval))
+			continue
+		}
+		// Other failures, e.g. network errors, are retried according to
+		// Config.RetryPolicy
+		failures++
+		var retry bool
+		if wait, retry = m.retryPolicy().Retry(failures, errorResponse(err), err); !retry {
+			return nil, fmt.Errorf("device access token: %w", asTokenError(err))
+		}
+		m.logger(ctx).Warn("Device token request failed, retrying",
+			slog.Int("attempt", failures), slog.Duration("wait", wait), slog.Any("error", err))
+	}
+	token, err := m.acceptToken(ctx, token, m.Config.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.saveToken(ctx, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// formatDeviceCode is the default Config.DeviceCodeFormatter. It boxes the
+// user code so that it stands out in the terminal.
+func formatDeviceCode(userCode, verificationURI string) string {
+	line := strings.Repeat("─", utf8.RuneCountInString(userCode)+4)
+	return fmt.Sprintf("\nTo sign in, open %s and enter the code:\n\n"+
+		"    ┌%s┐\n"+
+		"    │  %s  │\n"+
+		"    └%s┘\n\n", verificationURI, line, userCode, line)
+}
@@ -35,7 +35,9 @@
 //   - Endpoint: Provider's OAuth2 endpoint (e.g., google.Endpoint)
 //   - Scopes: List of permission scopes
 //   - TokenFile: Path to persist tokens (default: "token.json")
+//   - TokenStore: Custom token persistence, e.g. EnvTokenStore (overrides TokenFile)
 //   - LocalAddr: Local server address for callback (default: ":15440")
+//   - RedirectURLs: Registered redirect URIs; the first one whose port is free is used
 //   - ServerPath: Callback path (default: "/callback")
 //
 // Token Management:
@@ -47,7 +49,9 @@
 //   - Persists the token to disk for reuse
 //   - Automatically refreshes expired tokens
 //
-// Tokens are stored in JSON format at the path specified by Config.TokenFile.
+// Tokens are stored in JSON format at the path specified by Config.TokenFile,
+// unless a TokenStore is configured. EnvTokenStore reads the token from an
+// environment variable, for containers with read-only filesystems.
 // If a valid token exists, it will be reused without initiating a new authorization flow.
 //
 // Advanced Usage:
@@ -62,6 +66,13 @@
 //	tokenSource := manager.TokenSource(ctx, token)
 //	client := oauth2.NewClient(ctx, tokenSource)
 //
+// Applications running their own HTTP server can serve the callback
+// themselves and let the Manager complete the flow:
+//
+//	mux.Handle("/callback", manager.CallbackHandler())
+//	// ... start the server ...
+//	token, err := manager.WaitForToken(ctx)
+//
 // Logging:
 //
 // The Manager supports custom logging through the LoggerRepository interface:
@@ -71,6 +82,11 @@
 //	    LoggerRepository: customLogger,
 //	}
 //
+// By default, the Manager logs to the logger stored in the context with
+// WithLogger:
+//
+//	ctx = oauth2kit.WithLogger(ctx, slog.Default())
+//
 // Thread Safety:
 //
 // The Manager type is safe for concurrent use after initialization.
@@ -0,0 +1,183 @@
+package oauth2kit
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// dpopKey returns the key DPoP proofs are signed with: Config.DPoPKey, or
+// a key generated once per Manager.
+func (m *Manager) dpopKey() (*ecdsa.PrivateKey, error) {
+	if m.Config.DPoPKey != nil {
+		return m.Config.DPoPKey, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.generatedDPoPKey == nil {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("generate DPoP key: %w", err)
+		}
+		m.generatedDPoPKey = key
+	}
+	return m.generatedDPoPKey, nil
+}
+
+// dpopJWK returns the public JWK of key, with its members in the
+// lexicographic order required for thumbprints (RFC 7638).
+func dpopJWK(key *ecdsa.PrivateKey) map[string]string {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+	return map[string]string{
+		"crv": "P-256",
+		"kty": "EC",
+		"x":   base64.RawURLEncoding.EncodeToString(x),
+		"y":   base64.RawURLEncoding.EncodeToString(y),
+	}
+}
+
+// dpopThumbprint returns the JWK thumbprint of key (RFC 7638), sent as the
+// "dpop_jkt" parameter of the authorization request.
+func dpopThumbprint(key *ecdsa.PrivateKey) string {
+	// encoding/json sorts map keys, as required
+	b, _ := json.Marshal(dpopJWK(key))
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// dpopProof returns a DPoP proof JWT for a request (RFC 9449, section 4.2).
+// accessToken is set for requests to resource servers, and nonce once the
+// server has provided one.
+func dpopProof(key *ecdsa.PrivateKey, method, uri, accessToken, nonce string) (string, error) {
+	header := map[string]any{
+		"typ": "dpop+jwt",
+		"alg": "ES256",
+		"jwk": dpopJWK(key),
+	}
+	claims := map[string]any{
+		"jti": rand.Text(),
+		"htm": method,
+		"htu": uri,
+		"iat": time.Now().Unix(),
+	}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		claims["ath"] = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	var parts []string
+	for _, v := range []any{header, claims} {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, base64.RawURLEncoding.EncodeToString(b))
+	}
+	signingInput := strings.Join(parts, ".")
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("sign DPoP proof: %w", err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// dpopTransport adds DPoP proofs to the requests made to the token endpoint
+// and to requests carrying a DPoP-bound access token, retrying once when the
+// server asks for a nonce.
+type dpopTransport struct {
+	m    *Manager
+	base http.RoundTripper
+}
+
+func (t *dpopTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var accessToken string
+	if typ, token, _ := strings.Cut(req.Header.Get("Authorization"), " "); strings.EqualFold(typ, "DPoP") {
+		accessToken = token
+	} else if !t.m.isTokenRequest(req) {
+		return t.base.RoundTrip(req)
+	}
+	key, err := t.m.dpopKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// The body is buffered, so that the request can be retried with a nonce
+	var body []byte
+	if req.Body != nil {
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	uri := req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+	send := func() (*http.Response, error) {
+		proof, err := dpopProof(key, req.Method, uri, accessToken, t.m.dpopNonce(req.URL.Host))
+		if err != nil {
+			return nil, err
+		}
+		r := req.Clone(req.Context())
+		r.Header.Set("DPoP", proof)
+		if body != nil {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		return t.base.RoundTrip(r)
+	}
+
+	resp, err := send()
+	if err != nil {
+		return nil, err
+	}
+	nonce := resp.Header.Get("DPoP-Nonce")
+	if nonce == "" {
+		return resp, nil
+	}
+	retry := nonce != t.m.dpopNonce(req.URL.Host) &&
+		(resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized)
+	t.m.setDPoPNonce(req.URL.Host, nonce)
+	if !retry {
+		return resp, nil
+	}
+	resp.Body.Close()
+	return send()
+}
+
+// isTokenRequest reports whether req is sent to the token endpoint.
+func (m *Manager) isTokenRequest(req *http.Request) bool {
+	tokenURL, _, _ := strings.Cut(m.Config.Endpoint.TokenURL, "?")
+	return req.Method == http.MethodPost && tokenURL == req.URL.Scheme+"://"+req.URL.Host+req.URL.Path
+}
+
+func (m *Manager) dpopNonce(host string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.dpopNonces[host]
+}
+
+func (m *Manager) setDPoPNonce(host, nonce string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.dpopNonces == nil {
+		m.dpopNonces = make(map[string]string)
+	}
+	m.dpopNonces[host] = nonce
+}
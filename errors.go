@@ -0,0 +1,84 @@
+package oauth2kit
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrInvalidState is returned when a callback carries a state that does not
+// match a pending authorization request.
+var ErrInvalidState = errors.New("unknown or expired state")
+
+// ErrTokenExpired is returned when the stored token has expired and
+// cannot be refreshed, e.g. because Config.LocalOnly forbids it.
+var ErrTokenExpired = errors.New("token expired")
+
+// ErrNoRefreshToken is returned when an authorization yields no refresh
+// token and Config.RequireRefreshToken is set.
+var ErrNoRefreshToken = errors.New("no refresh token issued")
+
+// ErrClientMismatch is returned when the stored token was issued to another
+// client than Config.ClientID and Config.StrictClientMatch is set.
+var ErrClientMismatch = errors.New("stored token belongs to another client")
+
+// TokenError is returned when the token endpoint rejects a request, such as
+// an authorization code exchange or a refresh. It carries the error reported
+// by the provider (RFC 6749, section 5.2), e.g. "invalid_grant: Token has
+// been expired or revoked".
+//
+// The underlying *oauth2.RetrieveError remains reachable with errors.As.
+type TokenError struct {
+	// Code is the "error" field of the response, e.g. "invalid_grant".
+	Code string
+
+	// Description is the "error_description" field of the response.
+	Description string
+
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+
+	// Body is the raw response body.
+	Body []byte
+
+	// Err is the error returned by golang.org/x/oauth2.
+	Err error
+}
+
+func (e *TokenError) Error() string {
+	msg := e.Code
+	if e.Description != "" {
+		if msg != "" {
+			msg += ": "
+		}
+		msg += e.Description
+	}
+	if msg == "" {
+		msg = fmt.Sprintf("status %d", e.StatusCode)
+	}
+	return "token endpoint: " + msg
+}
+
+func (e *TokenError) Unwrap() error {
+	return e.Err
+}
+
+// asTokenError converts an *oauth2.RetrieveError found in err into a
+// *TokenError. Other errors are returned unchanged.
+func asTokenError(err error) error {
+	var re *oauth2.RetrieveError
+	if !errors.As(err, &re) {
+		return err
+	}
+	te := &TokenError{
+		Code:        re.ErrorCode,
+		Description: re.ErrorDescription,
+		Body:        re.Body,
+		Err:         err,
+	}
+	if re.Response != nil {
+		te.StatusCode = re.Response.StatusCode
+	}
+	return te
+}
@@ -0,0 +1,76 @@
+package oauth2kit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// maxFlowEvents is the number of events kept by a Manager; older events are
+// dropped.
+const maxFlowEvents = 100
+
+// FlowEventKind identifies a step of an authorization flow.
+type FlowEventKind string
+
+const (
+	EventServerStarted    FlowEventKind = "server_started"
+	EventBrowserOpened    FlowEventKind = "browser_opened"
+	EventCallbackReceived FlowEventKind = "callback_received"
+	EventCodeExchanged    FlowEventKind = "code_exchanged"
+	EventTokenRefreshed   FlowEventKind = "token_refreshed"
+	EventTokenSaved       FlowEventKind = "token_saved"
+	EventFlowFailed       FlowEventKind = "flow_failed"
+)
+
+// FlowEvent is a step of an authorization flow recorded by the Manager.
+type FlowEvent struct {
+	Time time.Time
+	Kind FlowEventKind
+
+	// Message describes the step, e.g. "Listening on 127.0.0.1:15440".
+	Message string
+
+	// Err is set when the step failed.
+	Err error
+}
+
+// Events returns the steps of the flows run by the Manager, oldest first,
+// e.g. for a setup wizard to render what happened once GetToken returns.
+// Only the latest 100 events are kept.
+func (m *Manager) Events() []FlowEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	events := make([]FlowEvent, 0, len(m.events))
+	if len(m.events) == maxFlowEvents {
+		events = append(events, m.events[m.nextEvent:]...)
+		return append(events, m.events[:m.nextEvent]...)
+	}
+	return append(events, m.events...)
+}
+
+// record adds an event to the Manager's ring buffer.
+func (m *Manager) record(kind FlowEventKind, msg string, err error) {
+	ev := FlowEvent{Time: m.now(), Kind: kind, Message: msg, Err: err}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.events) < maxFlowEvents {
+		m.events = append(m.events, ev)
+		return
+	}
+	m.events[m.nextEvent] = ev
+	m.nextEvent = (m.nextEvent + 1) % maxFlowEvents
+}
+
+// saveToken persists a newly obtained token.
+func (m *Manager) saveToken(ctx context.Context, token *oauth2.Token) error {
+	if err := m.tokenStore().Save(ctx, token); err != nil {
+		m.record(EventTokenSaved, "Failed to save token", err)
+		return fmt.Errorf("store token: %w", err)
+	}
+	m.logger(ctx).Debug("✓ Token saved")
+	m.record(EventTokenSaved, "Token saved", nil)
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+
+	"golang.org/x/oauth2/google"
+
+	"github.com/micheam/go-oauth2kit"
+)
+
+func main() {
+	// A structured logger shared by every tenant. The Manager picks it up
+	// from the context and annotates each line with the tenant's account.
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	loggers := &oauth2kit.StandardLoggerRepository{}
+
+	for _, tenant := range []string{"acme", "globex"} {
+		ctx := loggers.ContextWithLogger(context.Background(), logger)
+		ctx = oauth2kit.ContextWithAccount(ctx, tenant)
+
+		manager := &oauth2kit.Manager{
+			Config: oauth2kit.Config{
+				ClientID:     os.Getenv("CLIENT_ID"),
+				ClientSecret: os.Getenv("CLIENT_SECRET"),
+				Endpoint:     google.Endpoint,
+				Scopes:       []string{"email", "profile"},
+				TokenFile:    fmt.Sprintf("token-%s.json", tenant),
+			},
+			LoggerRepository: loggers,
+		}
+
+		// Log lines emitted here carry account=<tenant>
+		client, err := manager.NewOAuth2Client(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		resp, err := client.Get("https://www.googleapis.com/oauth2/v1/userinfo")
+		if err != nil {
+			log.Fatal(err)
+		}
+		resp.Body.Close()
+		logger.Info("userinfo fetched", slog.String("tenant", tenant), slog.Int("status", resp.StatusCode))
+	}
+}
@@ -0,0 +1,183 @@
+package oauth2kit
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// retryAttempts is the number of times a token request is attempted
+	// by default. Authorization codes stay valid for a short window, so
+	// retrying their exchange spares the user a new consent after a
+	// transient failure.
+	retryAttempts = 3
+
+	// retryBackoff is the default delay before the first retry.
+	retryBackoff = 500 * time.Millisecond
+)
+
+// RetryPolicy decides whether a failed token endpoint request is retried,
+// e.g. to plug in an existing resilience library. Retry is called after
+// each failed attempt, numbered from 1, with the response if the provider
+// answered, and returns how long to wait before retrying.
+type RetryPolicy interface {
+	Retry(attempt int, resp *http.Response, err error) (wait time.Duration, retry bool)
+}
+
+// ExponentialRetry is the default RetryPolicy. It retries network errors
+// and 5xx responses, doubling the wait after each attempt; errors reported
+// by the provider, such as invalid_grant, are not retried.
+type ExponentialRetry struct {
+	// Attempts is the maximum number of attempts.
+	// Default: 3
+	Attempts int
+
+	// Backoff is the wait before the first retry.
+	// Default: 500ms
+	Backoff time.Duration
+}
+
+func (p ExponentialRetry) Retry(attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	if attempt >= cmp.Or(p.Attempts, retryAttempts) || !isTransient(err) {
+		return 0, false
+	}
+	return cmp.Or(p.Backoff, retryBackoff) << (attempt - 1), true
+}
+
+// exchanger exchanges an authorization code for a token, as
+// oauth2.Config.Exchange does.
+type exchanger interface {
+	Exchange(ctx context.Context, conf *oauth2.Config, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error)
+}
+
+// oauth2Exchanger is the default exchanger.
+type oauth2Exchanger struct{}
+
+func (oauth2Exchanger) Exchange(ctx context.Context, conf *oauth2.Config, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	return conf.Exchange(ctx, code, opts...)
+}
+
+// exchange exchanges the authorization code for a token, retrying failures
+// according to Config.RetryPolicy.
+func (m *Manager) exchange(ctx context.Context, conf *oauth2.Config, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	ctx = m.oauth2Context(ctx)
+	var ex exchanger = oauth2Exchanger{}
+	if m.exchanger != nil {
+		ex = m.exchanger
+	}
+	return m.withRetry(ctx, "Token exchange", func() (*oauth2.Token, error) {
+		return ex.Exchange(ctx, conf, code, opts...)
+	})
+}
+
+// retryPolicy returns Config.RetryPolicy, or the default one.
+func (m *Manager) retryPolicy() RetryPolicy {
+	if m.Config.RetryPolicy != nil {
+		return m.Config.RetryPolicy
+	}
+	return ExponentialRetry{}
+}
+
+// withRetry runs the token endpoint request do, described by what in logs,
+// retrying failures according to Config.RetryPolicy.
+func (m *Manager) withRetry(ctx context.Context, what string, do func() (*oauth2.Token, error)) (*oauth2.Token, error) {
+	policy := m.retryPolicy()
+	for attempt := 1; ; attempt++ {
+		token, err := do()
+		if err == nil || ctx.Err() != nil {
+			return token, err
+		}
+		wait, retry := policy.Retry(attempt, errorResponse(err), err)
+		if !retry {
+			return nil, err
+		}
+		m.logger(ctx).Warn(what+" failed, retrying",
+			slog.Int("attempt", attempt), slog.Duration("wait", wait), slog.Any("error", err))
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// errorResponse returns the response of the provider a token endpoint
+// error carries, if any.
+func errorResponse(err error) *http.Response {
+	var re *oauth2.RetrieveError
+	if errors.As(err, &re) {
+		return re.Response
+	}
+	return nil
+}
+
+// isTransient reports whether a token endpoint error is worth retrying:
+// network errors and 5xx responses are, errors reported by the provider
+// (such as invalid_grant) are not.
+func isTransient(err error) bool {
+	var re *oauth2.RetrieveError
+	if errors.As(err, &re) {
+		return re.Response != nil && re.Response.StatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// acceptToken checks a newly issued token before it is used: the ID token,
+// if any, must match Config.ExpectedIssuer and Config.ExpectedAudience,
+// Config.TokenAcceptor must accept it, and a missing refresh token or a
+// scope downgrade is reported. The client ID and the time of the
+// authorization are recorded on the token.
+func (m *Manager) acceptToken(ctx context.Context, token *oauth2.Token, requested []string) (*oauth2.Token, error) {
+	if err := m.validateIDToken(token); err != nil {
+		return nil, err
+	}
+	if accept := m.Config.TokenAcceptor; accept != nil {
+		claims, err := idTokenClaims(token)
+		if err != nil {
+			if claims, err = m.AccessTokenClaims(token); err != nil {
+				claims = map[string]any{}
+			}
+		}
+		if err := accept(token, claims); err != nil {
+			if m.Config.RevokeRejectedTokens {
+				if rerr := m.revoke(ctx, token); rerr != nil {
+					m.logger(ctx).Warn("Failed to revoke rejected token", slog.Any("error", rerr))
+				}
+			}
+			return nil, err
+		}
+	}
+	if err := m.checkRefreshToken(ctx, token); err != nil {
+		return nil, err
+	}
+	extra := map[string]any{"granted_at": m.now().UTC().Format(time.RFC3339)}
+	if m.Config.ClientID != "" {
+		extra["client_id"] = m.Config.ClientID
+	}
+	token = withExtra(m.withExtraKeys(token, nil), extra)
+	return m.checkScopes(ctx, token, requested), nil
+}
+
+// checkRefreshToken reports a token issued without a refresh token, which
+// forces a new authorization once it expires.
+func (m *Manager) checkRefreshToken(ctx context.Context, token *oauth2.Token) error {
+	if token.RefreshToken != "" || m.Config.ResponseType == ResponseTypeToken {
+		return nil
+	}
+	const hint = "set Prompt to \"consent\" and request offline access to be issued one"
+	if m.Config.RequireRefreshToken {
+		return fmt.Errorf("%w; %s", ErrNoRefreshToken, hint)
+	}
+	m.logger(ctx).Warn("No refresh token issued; the token cannot be refreshed once expired",
+		slog.String("hint", hint))
+	return nil
+}
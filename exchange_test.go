@@ -0,0 +1,173 @@
+package oauth2kit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestRetryPolicyAppliesToTokenRequests(t *testing.T) {
+	for name, request := range map[string]func(ctx context.Context, m *Manager) (*oauth2.Token, error){
+		"refresh": func(ctx context.Context, m *Manager) (*oauth2.Token, error) {
+			return m.refresh(ctx, expiredToken("read"))
+		},
+		"scoped refresh": func(ctx context.Context, m *Manager) (*oauth2.Token, error) {
+			storeToken(t, m, expiredToken("read"))
+			return m.RefreshWithScopes(ctx, "read")
+		},
+		"password": func(ctx context.Context, m *Manager) (*oauth2.Token, error) {
+			m.Config.AllowPasswordGrant = true
+			return m.PasswordToken(ctx, "user", "password")
+		},
+		"device": func(ctx context.Context, m *Manager) (*oauth2.Token, error) {
+			m.Config.DevicePollInterval = time.Millisecond
+			return m.PollDeviceToken(ctx, &DeviceAuthResponse{DeviceCode: "device"})
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := newFakeProvider(t)
+			p.respond = func(n int, form url.Values) (int, map[string]any) {
+				switch n {
+				case 1:
+					return http.StatusServiceUnavailable, map[string]any{}
+				case 2:
+					if form.Get("grant_type") == deviceCodeGrantType {
+						return http.StatusBadRequest, map[string]any{"error": "authorization_pending"}
+					}
+				}
+				return http.StatusOK, map[string]any{"access_token": "access", "token_type": "Bearer", "expires_in": 3600}
+			}
+			m := p.manager(t)
+			m.Config.RetryPolicy = ExponentialRetry{Backoff: time.Millisecond}
+			token, err := request(context.Background(), m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token.AccessToken != "access" {
+				t.Errorf("access token %q, want %q", token.AccessToken, "access")
+			}
+		})
+	}
+}
+
+func TestRetryPolicyGivesUp(t *testing.T) {
+	p := newFakeProvider(t)
+	p.respond = func(n int, form url.Values) (int, map[string]any) {
+		return http.StatusBadGateway, map[string]any{}
+	}
+	m := p.manager(t)
+	m.Config.RetryPolicy = ExponentialRetry{Attempts: 2, Backoff: time.Millisecond}
+	if _, err := m.refresh(context.Background(), expiredToken("read")); err == nil {
+		t.Fatal("refresh succeeded")
+	}
+	if n := p.calls(); n != 2 {
+		t.Errorf("%d token requests, want 2", n)
+	}
+}
+
+// fakeExchanger is an exchanger answering with token and err, recording the
+// codes exchanged.
+type fakeExchanger struct {
+	token *oauth2.Token
+	err   error
+	codes []string
+}
+
+func (f *fakeExchanger) Exchange(ctx context.Context, conf *oauth2.Config, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	f.codes = append(f.codes, code)
+	return f.token, f.err
+}
+
+func TestExchangeSeam(t *testing.T) {
+	issued := &oauth2.Token{AccessToken: "access", TokenType: "Bearer", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)}
+	for name, tc := range map[string]struct {
+		input   string
+		ex      *fakeExchanger
+		wantErr error
+		codes   []string
+	}{
+		"code": {
+			input: "code\n",
+			ex:    &fakeExchanger{token: issued},
+			codes: []string{"code"},
+		},
+		"redirect URL with a forged state": {
+			input:   "http://localhost:15440/callback?code=code&state=forged\n",
+			ex:      &fakeExchanger{token: issued},
+			wantErr: ErrInvalidState,
+		},
+		"provider error": {
+			input: "code\n",
+			ex: &fakeExchanger{err: &oauth2.RetrieveError{
+				Response:  &http.Response{StatusCode: http.StatusBadRequest},
+				ErrorCode: "invalid_grant",
+			}},
+			wantErr: &TokenError{},
+			codes:   []string{"code"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := newFakeProvider(t)
+			m := p.manager(t)
+			m.Config.CodeReader = strings.NewReader(tc.input)
+			m.exchanger = tc.ex
+
+			token, err := m.GetToken(context.Background())
+			switch want := tc.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Fatal(err)
+				}
+				if token.AccessToken != "access" {
+					t.Errorf("access token %q, want %q", token.AccessToken, "access")
+				}
+				if _, err := m.tokenStore().Load(context.Background()); err != nil {
+					t.Errorf("token not persisted: %v", err)
+				}
+			case *TokenError:
+				if !errors.As(err, &want) || want.Code != "invalid_grant" {
+					t.Errorf("got %v, want a TokenError", err)
+				}
+			default:
+				if !errors.Is(err, want) {
+					t.Errorf("got %v, want %v", err, want)
+				}
+			}
+			if !slices.Equal(tc.ex.codes, tc.codes) {
+				t.Errorf("exchanged codes %q, want %q", tc.ex.codes, tc.codes)
+			}
+			if n := p.calls(); n != 0 {
+				t.Errorf("%d requests to the token endpoint, want none", n)
+			}
+		})
+	}
+}
+
+func TestCodeReaderKeepsFollowingInput(t *testing.T) {
+	p := newFakeProvider(t)
+	m := p.manager(t)
+	input := strings.NewReader("first\nsecond\nanswer\n")
+	m.Config.CodeReader = input
+	ex := &fakeExchanger{token: &oauth2.Token{AccessToken: "access", Expiry: time.Now().Add(time.Hour)}}
+	m.exchanger = ex
+
+	for range 2 {
+		if _, err := m.authorize(context.Background(), m.Config.Scopes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !slices.Equal(ex.codes, []string{"first", "second"}) {
+		t.Errorf("exchanged codes %q, want one line each", ex.codes)
+	}
+	if rest, _ := io.ReadAll(input); string(rest) != "answer\n" {
+		t.Errorf("input left %q, want the following line", rest)
+	}
+}
@@ -0,0 +1,99 @@
+package oauth2kit
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// authState is the serialized form of a pending authorization request.
+type authState struct {
+	State       string    `json:"state"`
+	Verifier    string    `json:"verifier"`
+	Scopes      []string  `json:"scopes,omitempty"`
+	RedirectURL string    `json:"redirect_uri"`
+	Expiry      time.Time `json:"exp"`
+}
+
+// SerializeAuthState encodes req, built by AuthCodeURL, into an opaque
+// string so that the callback can be handled by another process, which
+// resumes it with ResumeAuthState. The string is encrypted and
+// authenticated with Config.AuthStateKey, since it carries the PKCE
+// verifier.
+func (m *Manager) SerializeAuthState(req *AuthRequest) (string, error) {
+	aead, err := m.authStateAEAD()
+	if err != nil {
+		return "", err
+	}
+	conf := m.oauth2ConfigOAuth2()
+	b, err := json.Marshal(authState{
+		State:       req.State,
+		Verifier:    req.Verifier,
+		Scopes:      conf.Scopes,
+		RedirectURL: conf.RedirectURL,
+		Expiry:      m.now().Add(stateTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, b, nil)), nil
+}
+
+// ResumeAuthState decodes a string made by SerializeAuthState and registers
+// the request as pending, so that its State and Verifier can then be passed
+// to ExchangeCode with the callback's code. The Manager must be configured
+// with the same scopes and redirect URI as the one that serialized it. It
+// returns ErrInvalidState if the string was tampered with or has expired.
+func (m *Manager) ResumeAuthState(s string) (*AuthRequest, error) {
+	aead, err := m.authStateAEAD()
+	if err != nil {
+		return nil, err
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) < aead.NonceSize() {
+		return nil, ErrInvalidState
+	}
+	b, err = aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrInvalidState
+	}
+	var st authState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, ErrInvalidState
+	}
+	if m.now().After(st.Expiry) {
+		return nil, ErrInvalidState
+	}
+	conf := m.oauth2ConfigOAuth2()
+	if st.RedirectURL != conf.RedirectURL {
+		return nil, fmt.Errorf("auth state was issued for redirect URI %q, not %q", st.RedirectURL, conf.RedirectURL)
+	}
+	if !slices.Equal(st.Scopes, conf.Scopes) {
+		return nil, fmt.Errorf("auth state was issued for other scopes: %v", st.Scopes)
+	}
+	m.stateStore().Put(st.State, st.Verifier, st.Expiry)
+	return &AuthRequest{State: st.State, Verifier: st.Verifier}, nil
+}
+
+// authStateAEAD returns the cipher sealing serialized auth states, keyed
+// with the SHA-256 digest of Config.AuthStateKey.
+func (m *Manager) authStateAEAD() (cipher.AEAD, error) {
+	if len(m.Config.AuthStateKey) == 0 {
+		return nil, errors.New("no auth state key configured")
+	}
+	key := sha256.Sum256(m.Config.AuthStateKey)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
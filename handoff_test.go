@@ -0,0 +1,42 @@
+package oauth2kit
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestResumeAuthStateInAnotherManager(t *testing.T) {
+	p := newFakeProvider(t)
+	web, worker := p.manager(t), p.manager(t)
+	web.Config.AuthStateKey = []byte("key")
+	worker.Config.AuthStateKey = []byte("key")
+	ctx := context.Background()
+
+	req, err := web.AuthCodeURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := web.SerializeAuthState(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := worker.ResumeAuthState(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *resumed != (AuthRequest{State: req.State, Verifier: req.Verifier}) {
+		t.Errorf("resumed %+v, want the state and verifier of %+v", resumed, req)
+	}
+	if _, err := worker.ExchangeCode(ctx, "code", resumed.Verifier, resumed.State); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.form(0).Get("code_verifier"); got != req.Verifier {
+		t.Errorf("code_verifier %q, want %q", got, req.Verifier)
+	}
+
+	worker.Config.AuthStateKey = []byte("other key")
+	if _, err := worker.ResumeAuthState(s); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("other key: got %v, want ErrInvalidState", err)
+	}
+}
@@ -0,0 +1,66 @@
+package oauth2kit
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const defaultIntrospectionCacheTTL = 30 * time.Second
+
+// introspection is a cached introspection result.
+type introspection struct {
+	accessToken string
+	active      bool
+	expiry      time.Time
+}
+
+// introspect asks the introspection endpoint whether the access token of
+// token is active (RFC 7662). Results are cached for
+// Config.IntrospectionCacheTTL.
+func (m *Manager) introspect(ctx context.Context, token *oauth2.Token) (bool, error) {
+	cfg := m.Config
+	if cfg.IntrospectionURL == "" {
+		return false, errors.New("no introspection endpoint configured")
+	}
+
+	m.mu.Lock()
+	cached := m.introspected
+	m.mu.Unlock()
+	if cached != nil && cached.accessToken == token.AccessToken && m.now().Before(cached.expiry) {
+		return cached.active, nil
+	}
+
+	resp, body, err := m.postClientForm(ctx, cfg.IntrospectionURL, url.Values{
+		"token":           {token.AccessToken},
+		"token_type_hint": {"access_token"},
+	})
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("introspection endpoint returned %s: %s", resp.Status, body)
+	}
+	var result struct {
+		Active bool `json:"active"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return false, fmt.Errorf("decode introspection response: %w", err)
+	}
+
+	m.mu.Lock()
+	m.introspected = &introspection{
+		accessToken: token.AccessToken,
+		active:      result.Active,
+		expiry:      m.now().Add(cmp.Or(cfg.IntrospectionCacheTTL, defaultIntrospectionCacheTTL)),
+	}
+	m.mu.Unlock()
+	return result.Active, nil
+}
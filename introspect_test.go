@@ -0,0 +1,57 @@
+package oauth2kit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestIntrospectionCacheFollowsClock(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		fmt.Fprint(w, `{"active":true}`)
+	}))
+	defer srv.Close()
+
+	clock := newFakeClock()
+	m := &Manager{Config: Config{ClientID: "client", IntrospectionURL: srv.URL, Clock: clock.Now}}
+	token := &oauth2.Token{AccessToken: "access"}
+	ctx := context.Background()
+	for _, advance := range []time.Duration{0, time.Second, defaultIntrospectionCacheTTL} {
+		clock.Advance(advance)
+		if active, err := m.introspect(ctx, token); err != nil || !active {
+			t.Fatalf("introspect = %v, %v", active, err)
+		}
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d introspection requests, want 2", n)
+	}
+}
+
+func TestIntrospectionClientAuthentication(t *testing.T) {
+	var form string
+	var basic bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm.Encode()
+		_, _, basic = r.BasicAuth()
+		fmt.Fprint(w, `{"active":true}`)
+	}))
+	defer srv.Close()
+
+	m := &Manager{Config: Config{ClientID: "client", ClientSecret: "secret", IntrospectionURL: srv.URL}}
+	m.Config.Endpoint.AuthStyle = oauth2.AuthStyleInParams
+	if _, err := m.introspect(context.Background(), &oauth2.Token{AccessToken: "access"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "client_id=client&client_secret=secret&token=access&token_type_hint=access_token"; form != want || basic {
+		t.Errorf("form %q with basic auth %v, want %q in the body", form, basic, want)
+	}
+}
@@ -0,0 +1,109 @@
+package oauth2kit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrOpaqueToken is returned by AccessTokenClaims for access tokens that
+// are not JWTs.
+var ErrOpaqueToken = errors.New("access token is not a JWT")
+
+// AccessTokenClaims decodes the claims of a JWT access token, such as those
+// issued by Azure AD or Auth0 for APIs, e.g. to read "roles", "scp" or
+// "exp" without a network call. It returns ErrOpaqueToken for access tokens
+// that are not JWTs.
+//
+// The signature is NOT verified. The claims are only trustworthy for a
+// token received directly from the provider; resource servers must verify
+// tokens presented by clients instead.
+func (m *Manager) AccessTokenClaims(token *oauth2.Token) (map[string]any, error) {
+	if strings.Count(token.AccessToken, ".") != 2 {
+		return nil, ErrOpaqueToken
+	}
+	claims, err := decodeJWTClaims(token.AccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrOpaqueToken, err)
+	}
+	return claims, nil
+}
+
+// ErrTokenValidation is returned when the ID token of a newly issued token
+// does not match Config.ExpectedIssuer or Config.ExpectedAudience.
+var ErrTokenValidation = errors.New("token validation failed")
+
+// validateIDToken checks the "iss" and "aud" claims of the ID token carried
+// by token, if any, against Config.ExpectedIssuer and
+// Config.ExpectedAudience, to detect tokens issued by another provider or
+// for another client.
+func (m *Manager) validateIDToken(token *oauth2.Token) error {
+	cfg := m.Config
+	if cfg.ExpectedIssuer == "" && cfg.ExpectedAudience == "" {
+		return nil
+	}
+	if idToken, _ := token.Extra("id_token").(string); idToken == "" {
+		return nil
+	}
+	claims, err := idTokenClaims(token)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrTokenValidation, err)
+	}
+	if cfg.ExpectedIssuer != "" {
+		if iss, _ := claims["iss"].(string); iss != cfg.ExpectedIssuer {
+			return fmt.Errorf("%w: issuer %q, expected %q", ErrTokenValidation, iss, cfg.ExpectedIssuer)
+		}
+	}
+	if cfg.ExpectedAudience != "" {
+		var aud []string
+		switch v := claims["aud"].(type) {
+		case string:
+			aud = []string{v}
+		case []any:
+			for _, a := range v {
+				if s, ok := a.(string); ok {
+					aud = append(aud, s)
+				}
+			}
+		}
+		if !slices.Contains(aud, cfg.ExpectedAudience) {
+			return fmt.Errorf("%w: audience %q, expected %q", ErrTokenValidation, aud, cfg.ExpectedAudience)
+		}
+	}
+	return nil
+}
+
+// idTokenClaims decodes the claims of the ID token carried by token. The
+// signature is not verified: the claims are only trusted, by
+// validateIDToken and Config.TokenAcceptor, for tokens received directly
+// from the token endpoint over TLS, never for tokens of the implicit grant.
+func idTokenClaims(token *oauth2.Token) (map[string]any, error) {
+	idToken, _ := token.Extra("id_token").(string)
+	if idToken == "" {
+		return nil, errors.New("no ID token")
+	}
+	return decodeJWTClaims(idToken)
+}
+
+// decodeJWTClaims decodes the payload of a JWT in compact serialization
+// without verifying its signature.
+func decodeJWTClaims(jwt string) (map[string]any, error) {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("decode JWT payload: %w", err)
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("decode JWT claims: %w", err)
+	}
+	return claims, nil
+}
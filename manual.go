@@ -0,0 +1,90 @@
+package oauth2kit
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// readCodeFlow runs the authorization flow for scopes without a local
+// server: the authorization URL is printed and the code is read from
+// Config.CodeReader. Either the code itself or the whole URL the browser
+// was redirected to is accepted; the state is checked in the latter case.
+func (m *Manager) readCodeFlow(ctx context.Context, scopes []string) (*oauth2.Token, error) {
+	if m.Config.ResponseType == ResponseTypeToken {
+		return nil, errors.New("the implicit grant cannot be used with Config.CodeReader")
+	}
+	conf := m.oauth2ConfigOAuth2()
+	conf.Scopes = scopes
+	state := rand.Text()
+	verifier, err := m.generateVerifier()
+	if err != nil {
+		return nil, err
+	}
+	authURL, _, err := m.authCodeURL(ctx, conf, state, verifier)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(m.GetWriter(), "Please open the following URL in your browser, then paste the authorization code or the URL you were redirected to:\n%s\n", authURL)
+
+	lines := make(chan string, 1)
+	readErr := make(chan error, 1)
+	go func() {
+		line, err := readLine(m.Config.CodeReader)
+		if line = strings.TrimSpace(line); line == "" {
+			if err == nil {
+				err = errors.New("empty input")
+			}
+			readErr <- fmt.Errorf("read authorization code: %w", err)
+			return
+		}
+		lines <- line
+	}()
+	var input string
+	select {
+	case input = <-lines:
+	case err := <-readErr:
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	code := input
+	if u, err := url.Parse(input); err == nil && u.Query().Has("code") {
+		if u.Query().Get("state") != state {
+			return nil, ErrInvalidState
+		}
+		code = u.Query().Get("code")
+	}
+	token, err := m.exchange(ctx, conf, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("token exchange: %w", asTokenError(err))
+	}
+	return m.acceptToken(ctx, token, scopes)
+}
+
+// readLine reads r up to and including the next newline. It reads one byte
+// at a time, unlike a bufio.Reader, so that the input following the line is
+// left in r for the next flow or for other prompts reading it.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			line = append(line, b[0])
+			if b[0] == '\n' {
+				return string(line), nil
+			}
+		}
+		if err != nil {
+			return string(line), err
+		}
+	}
+}
@@ -1,15 +1,21 @@
 package oauth2kit
 
 import (
+	"cmp"
 	"context"
-	"encoding/json"
+	"crypto/ecdsa"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
-	"runtime"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -23,12 +29,30 @@ type Manager struct {
 	Config Config
 
 	// LoggerRepository provides logging capabilities.
-	// If nil, no logging is performed.
+	// If nil, a StandardLoggerRepository is used; the field is left nil.
 	LoggerRepository
 
 	// Writer specifies the output writer for informational messages.
 	// If nil, os.Stdout is used.
 	Writer io.Writer
+
+	mu             sync.Mutex
+	flows          map[string]*pendingFlow // keyed by state
+	refreshing     *refreshCall            // in-flight refresh, if any
+	refreshBackoff time.Time               // refreshes are suspended until then
+	introspected   *introspection          // last introspection result
+	states         MemoryStateStore        // pending requests, without Config.StateStore
+
+	generatedDPoPKey *ecdsa.PrivateKey // used without Config.DPoPKey
+	dpopNonces       map[string]string // latest DPoP nonce, keyed by host
+
+	events    []FlowEvent // ring buffer of the latest flow events
+	nextEvent int         // index of the oldest event once events is full
+
+	// exchanger performs the authorization code exchange. If nil, the
+	// code is exchanged with golang.org/x/oauth2; tests replace it to fake
+	// the token endpoint.
+	exchanger exchanger
 }
 
 const (
@@ -40,155 +64,447 @@ func (m *Manager) oauth2ConfigOAuth2() *oauth2.Config {
 	return m.Config.oauth2Config()
 }
 
+// OAuth2Config returns a copy of the oauth2.Config built from the Manager's
+// Config, for using features of golang.org/x/oauth2 not wrapped by the
+// Manager. Changes to the copy do not affect the Manager.
+func (m *Manager) OAuth2Config() *oauth2.Config {
+	conf := m.oauth2ConfigOAuth2()
+	conf.Scopes = slices.Clone(conf.Scopes)
+	return conf
+}
+
 func (c *Manager) TokenSource(ctx context.Context, t *oauth2.Token) oauth2.TokenSource {
-	return c.oauth2ConfigOAuth2().TokenSource(ctx, t)
+	return c.oauth2ConfigOAuth2().TokenSource(c.oauth2Context(ctx), t)
+}
+
+// ReuseTokenSource returns a token source starting from the current valid
+// token, obtained as by NewOAuth2Client. The token is reused while
+// TokenUsable holds, against Config.Clock, ExpiryDelta and ClockSkew, so
+// Token calls are cheap, and refreshed tokens are persisted to the token
+// store.
+//
+// Unlike the source returned by TokenSource, which refreshes the given
+// token in memory only, it persists refreshed tokens and shares refreshes
+// with the other clients of the Manager.
+func (m *Manager) ReuseTokenSource(ctx context.Context) (oauth2.TokenSource, error) {
+	res, err := m.validToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &persistingTokenSource{ctx: ctx, m: m, token: res.Token}, nil
 }
 
 func (m *Manager) NewOAuth2Client(ctx context.Context) (*http.Client, error) {
-	token, err := m.GetToken(ctx)
+	res, err := m.validToken(ctx)
 	if err != nil {
 		return nil, err
 	}
-	ts := m.TokenSource(ctx, token)
+	return m.newClient(ctx, res.Token), nil
+}
 
-	// Force token validation and refresh if expired
-	validToken, err := ts.Token()
+// StaticClient returns a client authorizing requests with the stored token
+// as is: it is never refreshed nor persisted, even once expired, and no
+// authorization flow is started. It serves auditing what a token can do
+// and reproducing expiry-related issues. It returns ErrTokenNotFound if no
+// token is stored.
+func (m *Manager) StaticClient(ctx context.Context) (*http.Client, error) {
+	token, err := m.tokenStore().Load(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("validate/refresh token: %w", err)
+		return nil, err
 	}
+	return &http.Client{Transport: &authTransport{
+		src:  oauth2.StaticTokenSource(token),
+		base: cmp.Or[http.RoundTripper](m.httpClient().Transport, http.DefaultTransport),
+	}}, nil
+}
 
-	// Save refreshed token if it changed
-	if validToken.AccessToken != token.AccessToken ||
-		validToken.RefreshToken != token.RefreshToken ||
-		!validToken.Expiry.Equal(token.Expiry) {
-		if err := store(m.Config.TokenFile, validToken); err != nil {
-			// Log warning but don't fail the request
-			logger := m.LoggerFromContext(ctx)
-			logger.Warn(fmt.Sprintf("Failed to save refreshed token to %s: %v", m.Config.TokenFile, err))
+// NewVerifiedOAuth2Client is like NewOAuth2Client, but also asks the
+// provider's introspection endpoint (Config.IntrospectionURL) whether the
+// token is still active. A token revoked server-side passes local expiry
+// checks; if it is reported inactive, a new authorization flow is started.
+//
+// Introspection results are cached for Config.IntrospectionCacheTTL.
+func (m *Manager) NewVerifiedOAuth2Client(ctx context.Context) (*http.Client, error) {
+	res, err := m.validToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token := res.Token
+	active, err := m.introspect(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("introspect token: %w", err)
+	}
+	if !active {
+		m.logger(ctx).Info("Token is no longer active, re-authorizing")
+		if token, err = m.authorizeAndSave(ctx, m.reauthScopes(token)); err != nil {
+			return nil, err
 		}
 	}
+	return m.newClient(ctx, token), nil
+}
 
-	return oauth2.NewClient(ctx, ts), nil
+// newClient returns an HTTP client authenticating requests with token and
+// the tokens refreshed after it.
+func (m *Manager) newClient(ctx context.Context, token *oauth2.Token) *http.Client {
+	ts := &persistingTokenSource{ctx: ctx, m: m, token: token}
+	var rt http.RoundTripper = &authTransport{
+		src:  ts,
+		base: cmp.Or[http.RoundTripper](m.httpClient().Transport, http.DefaultTransport),
+	}
+	if m.Config.ReauthOn401 {
+		rt = &reauthTransport{src: ts, base: rt}
+	}
+	return &http.Client{Transport: rt}
 }
 
-func (m *Manager) GetWriter() io.Writer {
-	if m.Writer != nil {
-		return m.Writer
+// httpClient returns the client used for requests to the provider.
+func (m *Manager) httpClient() *http.Client {
+	client := http.DefaultClient
+	if m.Config.HTTPClient != nil {
+		client = m.Config.HTTPClient
 	}
-	return os.Stdout
+	base := cmp.Or[http.RoundTripper](client.Transport, http.DefaultTransport)
+	rt := base
+	if len(m.Config.TokenRequestHeaders) > 0 {
+		rt = &headerTransport{m: m, base: rt}
+	}
+	if m.Config.DPoP {
+		rt = &dpopTransport{m: m, base: rt}
+	}
+	if m.Config.TraceHTTP {
+		rt = &traceTransport{m: m, base: rt}
+	}
+	if m.Config.BeforeExchange != nil {
+		rt = &exchangeHookTransport{m: m, base: rt}
+	}
+	if m.Config.Debug {
+		rt = &debugTransport{m: m, base: rt}
+	}
+	if rt == base {
+		return client
+	}
+	c := *client
+	c.Transport = rt
+	return &c
 }
 
-func (m *Manager) GetToken(ctx context.Context) (*oauth2.Token, error) {
-	if m.LoggerRepository == nil {
-		m.LoggerRepository = &StandardLoggerRepository{}
+// oauth2Context returns ctx carrying the client returned by httpClient, if
+// it is not the default one, for the requests made by golang.org/x/oauth2.
+func (m *Manager) oauth2Context(ctx context.Context) context.Context {
+	client := m.httpClient()
+	if client == http.DefaultClient {
+		return ctx
+	}
+	return context.WithValue(ctx, oauth2.HTTPClient, client)
+}
+
+// AuthorizationHeader returns the value of the Authorization header
+// carrying the current valid token, e.g. "Bearer ya29...", for transports
+// that do not accept an *http.Client, such as gRPC or websockets. The token
+// is refreshed and persisted first if needed.
+func (m *Manager) AuthorizationHeader(ctx context.Context) (string, error) {
+	res, err := m.validToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	return authorization(res.Token), nil
+}
+
+// CurlAuthHeader returns a curl header option carrying the current valid
+// token, e.g. `-H "Authorization: Bearer ya29..."`, to reproduce API calls
+// outside the program while debugging.
+//
+// The result exposes the access token in clear text. Never log it nor share
+// it, and only call this method when explicitly asked to.
+func (m *Manager) CurlAuthHeader(ctx context.Context) (string, error) {
+	header, err := m.AuthorizationHeader(ctx)
+	if err != nil {
+		return "", err
 	}
-	logger := m.LoggerFromContext(ctx)
+	return fmt.Sprintf("-H \"Authorization: %s\"", header), nil
+}
+
+// TokenResult is a token returned by Authenticate, along with how it was
+// obtained.
+type TokenResult struct {
+	Token *oauth2.Token
+
+	// Interactive reports whether a new authorization flow was completed,
+	// rather than a stored token being reused.
+	Interactive bool
 
-	cfg := m.Config
-	tokenFile := cfg.TokenFile
+	// Refreshed reports whether the stored token had expired and was
+	// refreshed.
+	Refreshed bool
+}
 
-	_, err := os.Stat(tokenFile)
-	if err != nil && !os.IsNotExist(err) {
+// Authenticate returns a usable token, like the one NewOAuth2Client
+// authenticates with: the stored token, refreshed and persisted if it has
+// expired, or the token of a new authorization flow if there is none. The
+// result tells which happened, e.g. to greet the user only after they
+// actually signed in.
+func (m *Manager) Authenticate(ctx context.Context) (*TokenResult, error) {
+	res, err := m.validToken(ctx)
+	if err != nil {
 		return nil, err
 	}
+	if !res.Interactive {
+		m.notifyReuse(ctx, res.Token)
+	}
+	return res, nil
+}
 
-	// Not Yet Create, nor Load any Token => Need to Newly Authenticate.
-	if err != nil && os.IsNotExist(err) {
+// AuthenticateParallel runs Authenticate on each of managers concurrently,
+// e.g. to sign several accounts or providers in at once, and returns the
+// results keyed like managers. Managers running an authorization flow must
+// listen on distinct addresses; LocalAddr ":0" picks a free port for each.
+// Managers must not share a token file either, as each would overwrite the
+// token of the others: an error is returned, before any authentication, if
+// two of them use the same TokenFile (the default one included) unless
+// NamespaceTokens keeps their tokens under distinct keys. Custom
+// TokenStores are not checked and must be distinct as well.
+// Failures are joined in the returned error, along with the results of the
+// managers that succeeded.
+func AuthenticateParallel(ctx context.Context, managers map[string]*Manager) (map[string]*TokenResult, error) {
+	if err := checkSharedTokenFiles(managers); err != nil {
+		return nil, err
+	}
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]*TokenResult, len(managers))
+		errs    []error
+	)
+	for key, m := range managers {
+		wg.Go(func() {
+			res, err := m.Authenticate(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			results[key] = res
+		})
+	}
+	wg.Wait()
+	return results, errors.Join(errs...)
+}
 
-		localAddr := defaultLocalAddr
-		if addr := cfg.LocalAddr; addr != "" {
-			localAddr = addr
+// checkSharedTokenFiles returns an error if two of managers store their
+// token at the same place of the same file.
+func checkSharedTokenFiles(managers map[string]*Manager) error {
+	owners := make(map[string]string, len(managers))
+	for _, key := range slices.Sorted(maps.Keys(managers)) {
+		var path, place string
+		switch s := managers[key].tokenStore().(type) {
+		case *FileTokenStore:
+			path = s.Path
+		case *NamespacedFileTokenStore:
+			path, place = s.Path, s.Key
+		default:
+			continue
 		}
-		// Generate PKCE verifier - oauth2 package now handles this internally
-		verifier := oauth2.GenerateVerifier()
-
-		// Create authorization URL with PKCE parameters using S256ChallengeOption
-		authURL := m.oauth2ConfigOAuth2().AuthCodeURL(
-			"state-token",
-			oauth2.AccessTypeOffline,
-			oauth2.S256ChallengeOption(verifier),
-		)
-
-		// Channel to receive authorization code
-		codeChan := make(chan string)
-		errorChan := make(chan error)
-
-		// Start local server to receive callback
-		server := &http.Server{Addr: localAddr}
-		path := cfg.ServerPath
-		if path == "" {
-			path = defaultServerPath
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
 		}
-		http.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
-			code := r.URL.Query().Get("code")
-			if code == "" {
-				errorChan <- fmt.Errorf("no authorization code received")
-				fmt.Fprintf(w, "Error: No authorization code received")
-				return
-			}
+		place = filepath.Clean(path) + "#" + place
+		if owner, ok := owners[place]; ok {
+			return fmt.Errorf("managers %s and %s share the token file %s", owner, key, path)
+		}
+		owners[place] = key
+	}
+	return nil
+}
 
-			codeChan <- code
-			html := `<html>
-			  <body>
-				<h1>Authentication Successful!</h1>
-				<p>You can close this window and return to the terminal.</p>
-			  </body>
-			  </html>`
-			fmt.Fprint(w, html)
-		})
+// EnsureAuthenticated checks, without starting an authorization flow, that
+// a usable token is stored, e.g. to fail fast at startup or in a health
+// check. An expired token is refreshed and persisted, which is the only
+// case the provider is contacted. It returns ErrTokenNotFound if no token
+// is stored for this client, and ErrTokenExpired if the token has expired
+// and cannot be refreshed.
+func (m *Manager) EnsureAuthenticated(ctx context.Context) error {
+	token, err := m.tokenStore().Load(ctx)
+	if err != nil {
+		return err
+	}
+	if clientID, _ := token.Extra("client_id").(string); clientID != "" && clientID != m.Config.ClientID {
+		return fmt.Errorf("%w: stored token was issued to %q", ErrClientMismatch, clientID)
+	}
+	if m.tokenTooOld(token) {
+		return fmt.Errorf("%w: authorization is older than MaxTokenAge", ErrTokenExpired)
+	}
+	if m.TokenUsable(token) {
+		return nil
+	}
+	if token.RefreshToken == "" {
+		return fmt.Errorf("%w at %s and cannot be refreshed", ErrTokenExpired, token.Expiry.Format(time.RFC3339))
+	}
+	_, err = m.refresh(ctx, token)
+	return err
+}
 
-		// Start server in goroutine
-		go func() {
-			if err := server.ListenAndServe(); err != http.ErrServerClosed {
-				errorChan <- err
-			}
-		}()
+// IsAuthenticated reports whether EnsureAuthenticated succeeds.
+func (m *Manager) IsAuthenticated(ctx context.Context) bool {
+	return m.EnsureAuthenticated(ctx) == nil
+}
 
-		// Open browser to authorization URL
-		fmt.Println("Opening browser for authentication...")
-		if err := openURL(authURL); err != nil {
-			logger.Warn("Failed to open browser: " + err.Error())
-			fmt.Fprintf(m.GetWriter(), "Please open the following URL in your browser:\n%s\n", authURL)
-		}
+// validToken returns the stored token, refreshing and persisting it if it
+// has expired. An authorization flow is started if there is no token yet.
+func (m *Manager) validToken(ctx context.Context) (*TokenResult, error) {
+	res, err := m.getToken(ctx, m.Config.Scopes, false)
+	if err != nil {
+		return nil, err
+	}
+	if m.TokenUsable(res.Token) {
+		return res, nil
+	}
+	return m.renew(ctx, res.Token)
+}
 
-		// Wait for authorization code
-		var authCode string
-		select {
-		case authCode = <-codeChan:
-			fmt.Fprintln(m.GetWriter(), "\n✓ Authorization code received")
-		case err := <-errorChan:
-			logger.Error("Error during authorization: " + err.Error())
-		case <-time.After(5 * time.Minute):
-			logger.Error("Timeout waiting for authorization code")
-		}
+// logger returns the logger for ctx, annotated with the account carried by
+// ctx, if any.
+func (m *Manager) logger(ctx context.Context) *slog.Logger {
+	// The default repository is used without being assigned, so that
+	// concurrent calls do not race on the exported field.
+	var repo LoggerRepository = &StandardLoggerRepository{}
+	if m.LoggerRepository != nil {
+		repo = m.LoggerRepository
+	}
+	logger := repo.LoggerFromContext(ctx)
+	if m.Config.Debug && !logger.Enabled(ctx, slog.LevelDebug) {
+		logger = slog.New(debugHandler{logger.Handler()})
+	}
+	if account, ok := AccountFromContext(ctx); ok {
+		logger = logger.With(slog.String("account", account))
+	}
+	return logger
+}
 
-		// Shutdown the server
-		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-		defer cancel()
-		if err := server.Shutdown(ctx); err != nil {
-			logger.Error("Server shutdown error: " + err.Error())
-		}
+func (m *Manager) GetWriter() io.Writer {
+	if m.Writer != nil {
+		return m.Writer
+	}
+	return os.Stdout
+}
+
+// inform writes an informational message line to the writer, unless
+// Config.Quiet is set. Messages the user must act upon are written directly.
+func (m *Manager) inform(msg string) {
+	if !m.Config.Quiet {
+		fmt.Fprintln(m.GetWriter(), msg)
+	}
+}
 
-		// Exchange authorization code for token with PKCE verifier
-		fmt.Fprintln(m.GetWriter(), "Exchanging authorization code for token...")
-		token, err := m.oauth2ConfigOAuth2().Exchange(ctx, authCode, oauth2.VerifierOption(verifier))
-		if err != nil {
-			return nil, fmt.Errorf("token exchange: %w", err)
+// notifyReuse tells the user that a stored token is reused, through
+// Config.OnReuseToken or an info log naming the signed-in identity. It is
+// called by GetToken and Authenticate only, not for every client built.
+func (m *Manager) notifyReuse(ctx context.Context, token *oauth2.Token) {
+	if m.Config.OnReuseToken != nil {
+		m.Config.OnReuseToken(token)
+		return
+	}
+	if m.Config.Quiet {
+		return
+	}
+	msg := "Using existing credentials"
+	if claims, err := idTokenClaims(token); err == nil {
+		for _, key := range []string{"email", "preferred_username", "name", "sub"} {
+			if v, _ := claims[key].(string); v != "" {
+				msg += " for " + v
+				break
+			}
 		}
+	}
+	if !token.Expiry.IsZero() {
+		if remaining := token.Expiry.Sub(m.now()); remaining > 0 {
+			msg += fmt.Sprintf(" (expires in %s)", remaining.Round(time.Minute))
+		} else {
+			msg += " (expired)"
+		}
+	}
+	m.logger(ctx).Info(msg)
+}
+
+func (m *Manager) GetToken(ctx context.Context) (*oauth2.Token, error) {
+	res, err := m.getToken(ctx, m.Config.Scopes, false)
+	if err != nil {
+		return nil, err
+	}
+	if !res.Interactive {
+		m.notifyReuse(ctx, res.Token)
+	}
+	return res.Token, nil
+}
 
-		// Save token to file
-		if err := store(tokenFile, token); err != nil {
-			return nil, fmt.Errorf("store token: %w", err)
+// GetTokenWithScopes is like GetToken, but requests scopes instead of
+// Config.Scopes. If the stored token was not granted all of them, a new
+// authorization flow is started for scopes.
+func (m *Manager) GetTokenWithScopes(ctx context.Context, scopes ...string) (*oauth2.Token, error) {
+	res, err := m.getToken(ctx, scopes, true)
+	if err != nil {
+		return nil, err
+	}
+	if !res.Interactive {
+		m.notifyReuse(ctx, res.Token)
+	}
+	return res.Token, nil
+}
+
+func (m *Manager) getToken(ctx context.Context, scopes []string, checkScopes bool) (*TokenResult, error) {
+	logger := m.logger(ctx)
+
+	// Load existing token from the store
+	logger.Debug("Loading stored token")
+	token, err := m.tokenStore().Load(ctx)
+	if err == nil {
+		if clientID, _ := token.Extra("client_id").(string); clientID != "" && clientID != m.Config.ClientID {
+			if m.Config.StrictClientMatch {
+				return nil, fmt.Errorf("%w: stored token was issued to %q", ErrClientMismatch, clientID)
+			}
+			logger.Warn("Stored token was issued to another client, re-authorizing")
+			err = ErrTokenNotFound
+		} else if m.tokenTooOld(token) {
+			logger.Info("Stored token exceeds MaxTokenAge, re-authorizing", slog.Duration("max_age", m.Config.MaxTokenAge))
+			// Re-authorize for the scopes granted so far as well
+			scopes = unionScopes(scopes, m.grantedScopes(token))
+			err = ErrTokenNotFound
+		}
+	}
+	switch {
+	case err == nil:
+		missing := missingScopes(m.grantedScopes(token), scopes)
+		if !checkScopes || len(missing) == 0 {
+			return &TokenResult{Token: token}, nil
 		}
-		logger.Debug("✓ Token saved to file: " + tokenFile)
-		return token, nil
+		logger.Info("Stored token lacks requested scopes, re-authorizing", slog.Any("missing", missing))
+		// Keep the scopes granted previously so that re-authorizing never
+		// downgrades the token.
+		scopes = unionScopes(scopes, m.grantedScopes(token))
+	case !errors.Is(err, ErrTokenNotFound):
+		return nil, fmt.Errorf("load token: %w", err)
 	}
 
-	// Load existing token from file
-	logger.Debug("Loading token from file: " + tokenFile)
-	token, err := load(tokenFile)
+	// Not Yet Create, nor Load any Token => Need to Newly Authenticate.
+	token, err = m.authorizeAndSave(ctx, scopes)
 	if err != nil {
-		return nil, fmt.Errorf("load token from file: %w", err)
+		return nil, err
+	}
+	return &TokenResult{Token: token, Interactive: true}, nil
+}
+
+// authorizeAndSave runs the interactive authorization flow for scopes and
+// persists the resulting token.
+func (m *Manager) authorizeAndSave(ctx context.Context, scopes []string) (*oauth2.Token, error) {
+	if m.Config.LocalOnly {
+		return nil, fmt.Errorf("%w; authorization is disabled by LocalOnly", ErrTokenNotFound)
+	}
+	token, err := m.authorize(ctx, scopes)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.saveToken(ctx, token); err != nil {
+		return nil, err
 	}
 	return token, nil
 }
@@ -202,14 +518,94 @@ type LoggerRepository interface {
 	ContextWithLogger(ctx context.Context, logger *slog.Logger) context.Context
 }
 
+// StandardLoggerRepository stores the logger in the context with WithLogger.
+// When the context carries no logger, an info-level text logger writing to
+// os.Stderr is used.
 type StandardLoggerRepository struct{}
 
 func (r *StandardLoggerRepository) LoggerFromContext(ctx context.Context) *slog.Logger {
+	if logger := LoggerFrom(ctx); logger != nil {
+		return logger
+	}
 	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo}))
 }
 
 func (r *StandardLoggerRepository) ContextWithLogger(ctx context.Context, logger *slog.Logger) context.Context {
-	return ctx // No-op for standard implementation
+	return WithLogger(ctx, logger)
+}
+
+// WithLogger returns a copy of ctx carrying logger. A Manager using the
+// default StandardLoggerRepository logs to it, so middleware can hand its
+// request-scoped logger down to the Manager.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// LoggerFrom returns the logger stored in ctx by WithLogger, or nil if there
+// is none.
+func LoggerFrom(ctx context.Context) *slog.Logger {
+	logger, _ := ctx.Value(loggerKey).(*slog.Logger)
+	return logger
+}
+
+// ----------------------------------------------------------------------------
+// Context values
+// ----------------------------------------------------------------------------
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	accountKey
+	identityKey
+)
+
+// ContextWithAccount returns a copy of ctx carrying an account identifier,
+// such as an end user or tenant name. The Manager adds it as the "account"
+// attribute to every log line emitted for operations using the context.
+func ContextWithAccount(ctx context.Context, account string) context.Context {
+	return context.WithValue(ctx, accountKey, account)
+}
+
+// AccountFromContext returns the account identifier carried by ctx, if any.
+func AccountFromContext(ctx context.Context) (string, bool) {
+	account, ok := ctx.Value(accountKey).(string)
+	return account, ok
+}
+
+// Identity is the authenticated user, as told by the ID token.
+type Identity struct {
+	// Subject is the "sub" claim, the user's identifier at the provider.
+	Subject string
+
+	// Email is the "email" claim, if granted.
+	Email string
+}
+
+// ContextWithIdentity returns a copy of ctx carrying the Identity of the
+// user the current valid token was issued to, retrievable with
+// IdentityFrom. The token is obtained as for Authenticate and must carry an
+// ID token.
+func (m *Manager) ContextWithIdentity(ctx context.Context) (context.Context, error) {
+	res, err := m.validToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := idTokenClaims(res.Token)
+	if err != nil {
+		return nil, fmt.Errorf("identity: %w", err)
+	}
+	var id Identity
+	id.Subject, _ = claims["sub"].(string)
+	id.Email, _ = claims["email"].(string)
+	return context.WithValue(ctx, identityKey, id), nil
+}
+
+// IdentityFrom returns the Identity stored in ctx by
+// Manager.ContextWithIdentity, if any.
+func IdentityFrom(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey).(Identity)
+	return id, ok
 }
 
 // ----------------------------------------------------------------------------
@@ -221,76 +617,525 @@ type Config struct {
 	ClientID string
 
 	// ClientSecret is the OAuth2 client secret issued by the provider.
+	// Stored tokens are bound to ClientID only, so rotating the secret
+	// keeps them, refresh tokens included.
 	ClientSecret string
 
+	// PublicClient marks the client as public (RFC 6749, section 2.1), such
+	// as a CLI whose secret cannot be kept confidential: ClientSecret is
+	// never sent, neither when exchanging the code nor when refreshing.
+	PublicClient bool
+
+	// AuthStyle selects how the client credentials are sent to the token
+	// endpoint for every request, including refreshes. If zero,
+	// Endpoint.AuthStyle is used, which auto-detects the style by default.
+	AuthStyle oauth2.AuthStyle
+
 	// Scopes specifies the list of requested permission scopes.
 	Scopes []string
 
 	// Endpoint contains the provider's OAuth2 endpoint URLs.
 	Endpoint oauth2.Endpoint
 
-	// ServerPath is the path for the local callback server.
+	// ServerPath is the path for the local callback server. Requests to
+	// any path ending with it are accepted, so that prefixes added by
+	// reverse proxies are tolerated.
 	// Default: "/callback"
 	ServerPath string
 
-	// LocalAddr is the address for the local callback server.
+	// LocalAddr is the address for the local callback server. Port 0
+	// (":0") picks a free port for each flow, for providers accepting any
+	// port on loopback redirect URIs, so that several flows can run at once.
+	//
+	// In sandboxes blocking loopback TCP, it can be a Unix socket such as
+	// "unix:/run/app/callback.sock", with a helper process forwarding the
+	// redirect to it. The redirect URI is then RedirectURLs[0], or
+	// "http://localhost:15440" followed by ServerPath.
 	// Default: ":15440"
 	LocalAddr string
 
+	// RedirectURLs lists redirect URIs registered with the provider, such as
+	// "http://localhost:15440/callback" and "http://localhost:15441/callback".
+	// The local callback server binds the port of the first URL that is
+	// available and uses that URL as the redirect URI. When set, it takes
+	// precedence over LocalAddr.
+	RedirectURLs []string
+
+	// RedirectURL is the redirect URI the provider sends the user back to,
+	// when it is not the local callback server itself, e.g. the external
+	// URL of a proxy forwarding to it. It is sent as is with both the
+	// authorization request and the code exchange, which must match byte
+	// for byte, while the local server keeps listening on LocalAddr. When
+	// set, it takes precedence over RedirectURLs.
+	RedirectURL string
+
+	// ServerTimeouts configures the timeouts of the local callback server.
+	// Zero fields use the defaults of ServerTimeouts.
+	ServerTimeouts ServerTimeouts
+
+	// Offline controls whether "access_type=offline" is sent with the
+	// authorization request, which Google requires to issue a refresh token.
+	// Set it to false for providers that reject unknown parameters.
+	// Default (nil): true
+	Offline *bool
+
+	// AccessType is sent as the "access_type" parameter of the authorization
+	// request, e.g. "offline" or "online". It takes precedence over Offline.
+	AccessType string
+
+	// Prompt is sent as the "prompt" parameter of the authorization request,
+	// e.g. "consent", "select_account" or "none".
+	Prompt string
+
+	// RequireRefreshToken makes an authorization that yields no refresh
+	// token fail with ErrNoRefreshToken instead of only logging a warning.
+	// Google, for instance, issues one on repeat authorizations only with
+	// Prompt "consent". It does not apply to the implicit grant.
+	RequireRefreshToken bool
+
+	// LoginHint is sent as the "login_hint" parameter of the authorization
+	// request to pre-fill the user's identity, e.g. an email address.
+	LoginHint string
+
+	// UseLoginHintFromToken makes re-authorizations send the email address,
+	// or else the subject, of the stored token's ID token as "login_hint",
+	// so that the user need not pick their account again. LoginHint takes
+	// precedence.
+	UseLoginHintFromToken bool
+
+	// ResponseType selects the OAuth2 response type requested from the
+	// provider. Default: "code" (authorization code flow with PKCE).
+	//
+	// Setting it to "token" enables the implicit grant for legacy providers
+	// that support nothing else. The token is returned in the URL fragment,
+	// which the callback page posts back to the local server. The implicit
+	// grant is deprecated and LESS SECURE: the access token passes through
+	// the browser, no refresh token is issued and PKCE cannot be used.
+	ResponseType string
+
+	// AllowPasswordGrant enables PasswordToken, which uses the resource
+	// owner password credentials grant. The grant is deprecated: the
+	// application handles the user's password, and neither MFA nor
+	// federated login is possible. Only enable it for trusted first-party
+	// applications.
+	AllowPasswordGrant bool
+
+	// AutoCloseTab makes the success page try to close the browser tab.
+	// Browsers only allow this for tabs opened by a script, so the page
+	// keeps its "you can close this window" message as a fallback.
+	AutoCloseTab bool
+
+	// SuccessHTML, if set, is the HTML document served once the callback
+	// has been received, instead of the default page. Assets it references
+	// can be served with CallbackRoutes.
+	SuccessHTML string
+
+	// CallbackRoutes are additional handlers served by the local callback
+	// server for the duration of the flow, keyed by http.ServeMux pattern,
+	// e.g. "/logo.png" served from an embed.FS for SuccessHTML. The flow
+	// fails if a pattern is invalid, conflicts with another one or matches
+	// ServerPath, like "/" does.
+	CallbackRoutes map[string]http.Handler
+
+	// SuccessRedirectURL, if set, is where the browser is redirected once
+	// the callback has been received, e.g. "/dashboard" for applications
+	// serving CallbackHandler themselves, instead of showing the success
+	// page. The redirect is sent before the code is exchanged.
+	SuccessRedirectURL string
+
+	// BrowserOpener opens the authorization URL in the user's browser.
+	// If nil, the operating system's default browser is launched.
+	BrowserOpener BrowserOpener
+
+	// BrowserEnv holds "KEY=value" entries added to the environment of the
+	// command launching the default browser, e.g. "DISPLAY=:0" for a
+	// forwarded X display. It is ignored when BrowserOpener is set.
+	BrowserEnv []string
+
+	// CodeReader, if set, makes the authorization flow read the code from
+	// it, e.g. os.Stdin in scripts, instead of starting a local server:
+	// the authorization URL is printed, and the user pastes the code or the
+	// URL the browser was redirected to, followed by a newline.
+	CodeReader io.Reader
+
+	// CopyToClipboard copies the authorization URL to the clipboard instead
+	// of opening the browser, for users on remote machines pasting it in a
+	// local browser. The URL is printed if the clipboard is unavailable.
+	// It relies on pbcopy, clip.exe, wl-copy, xclip or xsel.
+	CopyToClipboard bool
+
+	// OpeningBrowserMessage is printed to the Manager's writer before the
+	// browser is opened, e.g. to localize or brand it. It is not printed
+	// when Quiet is set.
+	// Default: "Opening browser for authentication..."
+	OpeningBrowserMessage string
+
+	// HTTPClient is used for the requests made to the provider, such as
+	// token exchanges, refreshes and introspection, and as the base of the
+	// clients returned by NewOAuth2Client.
+	// If nil, http.DefaultClient is used, whose transport honors the
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables, as does
+	// a client without Transport. A client with a transport of its own
+	// must configure the proxy itself, e.g. with http.ProxyFromEnvironment.
+	HTTPClient *http.Client
+
+	// RevocationURL is the provider's token revocation endpoint (RFC 7009).
+	RevocationURL string
+
+	// JWKSURL is the URL of the provider's JSON Web Key Set, holding the
+	// keys that sign its ID tokens.
+	JWKSURL string
+
+	// UserInfoURL is the provider's OpenID Connect userinfo endpoint.
+	UserInfoURL string
+
+	// IntrospectionURL is the provider's token introspection endpoint
+	// (RFC 7662), used by NewVerifiedOAuth2Client.
+	IntrospectionURL string
+
+	// IntrospectionCacheTTL is how long an introspection result is reused.
+	// Default: 30s
+	IntrospectionCacheTTL time.Duration
+
+	// PAREndpoint is the provider's pushed authorization request endpoint
+	// (RFC 9126). If set, authorization requests are posted to it and the
+	// browser is sent to Endpoint.AuthURL with the returned request_uri
+	// only, as FAPI compliant providers require.
+	PAREndpoint string
+
+	// TokenRequestHeaders are added to the requests made to the provider's
+	// endpoints (token, device authorization, introspection, revocation and
+	// pushed authorization requests), e.g. a tenant ID or an API key some
+	// providers require. They are not added to API calls.
+	TokenRequestHeaders http.Header
+
+	// BeforeExchange, if set, is called with the parameters of each
+	// authorization code exchange request before it is sent, and may add or
+	// change some, e.g. a client_assertion signed for this very request. An
+	// error aborts the exchange.
+	BeforeExchange func(ctx context.Context, params url.Values) error
+
+	// DPoP enables Demonstrating Proof-of-Possession (RFC 9449): tokens are
+	// bound to a key, whose thumbprint is sent with the authorization
+	// request, and every token request and API call made by the clients of
+	// NewOAuth2Client carries a proof signed with it.
+	DPoP bool
+
+	// DPoPKey is the P-256 key DPoP proofs are signed with. If nil, a key
+	// is generated for each Manager, so persisted DPoP-bound tokens cannot
+	// be used by the next run: set it to a key kept across runs, e.g. in a
+	// keyring, to reuse them.
+	DPoPKey *ecdsa.PrivateKey
+
+	// ExpectedIssuer and ExpectedAudience, if set, are compared with the
+	// "iss" and "aud" claims of the ID token returned with a new token,
+	// typically the issuer URL and the client ID. A mismatch fails the flow
+	// with ErrTokenValidation. The ID token signature is not verified, so
+	// the claims are only trusted when the token is received directly from
+	// the token endpoint: the implicit grant (ResponseType "token"), whose
+	// token passes through the browser, fails with ExpectedIssuer or
+	// ExpectedAudience.
+	ExpectedIssuer   string
+	ExpectedAudience string
+
+	// ShowQRCode prints the authorization URL as a QR code, so that the
+	// user can complete the authorization on a phone.
+	ShowQRCode bool
+
+	// RejectStaleRefresh makes a refresh fail with ErrStaleRefresh when the
+	// new token expires no later than the previous one; the new token is
+	// saved nonetheless, keeping a rotated refresh token. By default a
+	// warning is logged and further refreshes are suspended for 30 seconds
+	// while the previous token remains usable.
+	RejectStaleRefresh bool
+
+	// RetryPolicy decides whether failed token requests are retried:
+	// authorization code exchanges, refreshes (RefreshWithScopes
+	// included), the password grant and the polling of the device flow,
+	// besides its "authorization_pending" and "slow_down" answers. If nil,
+	// ExponentialRetry{} is used.
+	RetryPolicy RetryPolicy
+
+	// ExpiryDelta is how long before its expiry a token is refreshed
+	// proactively, so that it does not expire while a request is in flight.
+	// Default: 10s
+	ExpiryDelta time.Duration
+
+	// ClockSkew is the tolerated difference between the local clock and the
+	// provider's. Unlike ExpiryDelta, which refreshes tokens early on
+	// purpose, it compensates for a local clock running ahead: a token is
+	// used until ClockSkew past its recorded expiry (minus ExpiryDelta)
+	// instead of being refreshed or re-authorized early.
+	ClockSkew time.Duration
+
+	// ExtraKeys lists fields of the token response, beyond those the
+	// Manager relies on (scope, id_token), which are persisted with the
+	// token and kept across refreshes that omit them, e.g. "instance_url"
+	// or "tenant_id". Read them with oauth2.Token.Extra.
+	ExtraKeys []string
+
+	// MaxTokenAge, if positive, is how long the authorization behind a
+	// token is honored, refreshes included: past it, a new authorization
+	// flow is started even if the token could still be refreshed. Tokens
+	// stored without their authorization time count as too old.
+	MaxTokenAge time.Duration
+
+	// Clock returns the current time used in expiry checks.
+	// If nil, time.Now is used.
+	Clock func() time.Time
+
+	// LocalOnly restricts the Manager to the stored token, validated
+	// against Clock, for disconnected environments relying on long-lived
+	// tokens: it is never refreshed and no authorization flow is started.
+	// An expired token yields ErrTokenExpired, a missing one
+	// ErrTokenNotFound. (Offline already controls access_type.)
+	LocalOnly bool
+
+	// DeviceCodeFormatter renders the user code and verification URI shown
+	// by DeviceFlow. If nil, the code is displayed in a box.
+	DeviceCodeFormatter func(userCode, verificationURI string) string
+
+	// DevicePollInterval is the minimum interval between polling requests
+	// of the device flow. The interval returned by the provider is used if
+	// it is longer.
+	// Default: 5s if the provider returns none
+	DevicePollInterval time.Duration
+
+	// ExpiryWarning is how long before the expiry of a token without
+	// refresh token OnExpiryWarning is called.
+	// Default: 5m
+	ExpiryWarning time.Duration
+
+	// OnExpiryWarning, if set, is called once per token by the clients
+	// returned by NewOAuth2Client when their token cannot be refreshed and
+	// expires within ExpiryWarning, so that the user can re-authenticate at
+	// a convenient time.
+	OnExpiryWarning func(remaining time.Duration)
+
+	// StateStore keeps the PKCE verifiers of the requests built by
+	// Manager.AuthCodeURL, for Manager.ExchangeCallback and
+	// Manager.ExchangeCode. If nil, the Manager keeps them in memory, so
+	// that only the process which built a request can complete it.
+	StateStore StateStore
+
+	// PKCEVerifierLength is the length of the PKCE verifiers generated, from
+	// 43 to 128 characters (RFC 7636).
+	// Default: 43
+	PKCEVerifierLength int
+
+	// Rand is the entropy source of the PKCE verifiers, e.g. a certified
+	// generator in audited environments. If nil, crypto/rand is used.
+	Rand io.Reader
+
+	// AuthStateKey is the secret encrypting the authorization requests
+	// handed off to another process with Manager.SerializeAuthState. All
+	// processes share it; any length is accepted, 32 random bytes are
+	// recommended.
+	AuthStateKey []byte
+
+	// Debug logs every step of the flows at debug level, including the
+	// requests made to the provider, to troubleshoot them. Secrets such as
+	// codes and tokens are left out. If the logger discards debug messages,
+	// its handler is handed them anyway, keeping its destination and
+	// format; handlers filtering levels in Handle still drop them.
+	Debug bool
+
+	// TraceHTTP logs the requests made to the provider's endpoints (token,
+	// introspection, revocation...) and their responses at info level, with
+	// client secrets, codes and tokens redacted. It is meant for
+	// troubleshooting and off by default.
+	TraceHTTP bool
+
+	// Quiet suppresses the informational messages written to the Manager's
+	// writer, and the info log of a reused token. Instructions the user
+	// must follow, such as a URL to open, are still written; other logs
+	// follow the logger's level.
+	Quiet bool
+
+	// OnReuseToken, if set, is called when GetToken, GetTokenWithScopes or
+	// Authenticate reuse a stored token, instead of the default info log,
+	// e.g. to tell the user they are signed in.
+	OnReuseToken func(token *oauth2.Token)
+
+	// OnScopeDowngrade, if set, is called when the provider grants fewer
+	// scopes than requested, in addition to a warning being logged. The
+	// token is still used; calls needing the missing scopes will fail.
+	OnScopeDowngrade func(requested, granted []string)
+
 	// TokenFile is the path where tokens are persisted.
 	// Default: "token.json"
 	TokenFile string
+
+	// NamespaceTokens stores the token in TokenFile under the key returned
+	// by Manager.TokenKey, so that applications sharing the file do not
+	// overwrite each other's token. Existing files in the single-token
+	// format are not migrated.
+	NamespaceTokens bool
+
+	// TokenFormat is the layout of TokenFile. Use TokenFormatPortable to
+	// share the file with tools written in other languages.
+	// Default: TokenFormatNative
+	TokenFormat TokenFormat
+
+	// MaxTokenFileSize is the size above which TokenFile is rejected
+	// instead of being decoded, e.g. when it was replaced by garbage.
+	// Default: 1MB
+	MaxTokenFileSize int64
+
+	// TokenStore persists tokens between runs. If set, TokenFile is ignored.
+	// If nil, tokens are persisted to TokenFile.
+	TokenStore TokenStore
+
+	// TokenAcceptor, if set, is called with every newly issued token and
+	// the claims of its ID token, or of its access token if it is a JWT,
+	// before the token is persisted; claims is empty otherwise. Returning
+	// an error rejects the token, e.g. to only allow users of a domain
+	// ("hd" or "email" claims): it is not persisted and the error is
+	// returned by GetToken. The claims are not verified, so the implicit
+	// grant (ResponseType "token") fails with a TokenAcceptor: its token
+	// passes through the browser and could be forged.
+	TokenAcceptor func(token *oauth2.Token, claims map[string]any) error
+
+	// RevokeRejectedTokens revokes the tokens rejected by TokenAcceptor at
+	// RevocationURL, so that they cannot be used anymore.
+	RevokeRejectedTokens bool
+
+	// ReauthOn401 makes the clients returned by NewOAuth2Client retry a
+	// request once when the server answers 401 Unauthorized, e.g. because
+	// the token was revoked server-side: the token is refreshed, or a new
+	// authorization flow is run if that fails. Requests whose body cannot
+	// be sent again are not retried.
+	ReauthOn401 bool
+
+	// ReauthOnInvalidGrant makes the Manager discard a token whose refresh
+	// token the provider rejects as expired or revoked (invalid_grant) and
+	// run a new authorization flow, instead of failing with
+	// ErrRefreshTokenExpired.
+	ReauthOnInvalidGrant bool
+
+	// StrictClientMatch makes GetToken fail with ErrClientMismatch when the
+	// stored token was issued to a client other than ClientID, e.g. after
+	// switching to a new client. Only the client ID is compared: a rotated
+	// ClientSecret is no mismatch. By default a new authorization flow is
+	// started.
+	StrictClientMatch bool
+
+	// NoPersistence disables token storage: no file is read or written,
+	// TokenFile and TokenStore are ignored, and refreshed tokens are not
+	// saved. GetToken then always runs the authorization flow. Use it when
+	// the caller stores tokens itself, e.g. in a web session, and builds
+	// clients with TokenSource.
+	NoPersistence bool
 }
 
+// checkImplicit returns an error if the token validation configured
+// relies on the unverified claims of a token of the implicit grant.
+func (c *Config) checkImplicit() error {
+	if c.ResponseType != ResponseTypeToken {
+		return nil
+	}
+	if c.ExpectedIssuer != "" || c.ExpectedAudience != "" || c.TokenAcceptor != nil {
+		return errors.New("ExpectedIssuer, ExpectedAudience and TokenAcceptor cannot be used with the implicit grant, whose token passes through the browser unverified")
+	}
+	return nil
+}
+
+// Response types accepted by Config.ResponseType.
+const (
+	ResponseTypeCode  = "code"
+	ResponseTypeToken = "token"
+)
+
 func (c *Config) oauth2Config() *oauth2.Config {
+	endpoint := c.Endpoint
+	endpoint.AuthStyle = c.authStyle()
 	return &oauth2.Config{
 		ClientID:     c.ClientID,
-		ClientSecret: c.ClientSecret,
-		Endpoint:     c.Endpoint,
+		ClientSecret: c.clientSecret(),
+		Endpoint:     endpoint,
 		RedirectURL:  c.buildRedirectURL(),
 		Scopes:       c.Scopes,
 	}
 }
 
+// clientSecret returns the client secret sent to the provider, which is
+// never sent by public clients.
+func (c *Config) clientSecret() string {
+	if c.PublicClient {
+		return ""
+	}
+	return c.ClientSecret
+}
+
+// authStyle returns how the client authenticates to the token endpoint.
+// Public clients send their client ID in the request body.
+func (c *Config) authStyle() oauth2.AuthStyle {
+	switch {
+	case c.PublicClient:
+		return oauth2.AuthStyleInParams
+	case c.AuthStyle != oauth2.AuthStyleAutoDetect:
+		return c.AuthStyle
+	}
+	return c.Endpoint.AuthStyle
+}
+
+// authCodeOptions returns the options added to every authorization request.
+func (c *Config) authCodeOptions() []oauth2.AuthCodeOption {
+	var opts []oauth2.AuthCodeOption
+	switch {
+	case c.AccessType != "":
+		opts = append(opts, oauth2.SetAuthURLParam("access_type", c.AccessType))
+	case c.offline():
+		opts = append(opts, oauth2.AccessTypeOffline)
+	}
+	if c.Prompt != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("prompt", c.Prompt))
+	}
+	if c.LoginHint != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("login_hint", c.LoginHint))
+	}
+	return opts
+}
+
+func (c *Config) offline() bool {
+	return c.Offline == nil || *c.Offline
+}
+
 func (c *Config) buildRedirectURL() string {
+	if c.RedirectURL != "" {
+		return c.RedirectURL
+	}
+	if len(c.RedirectURLs) > 0 {
+		return c.RedirectURLs[0]
+	}
 	localAddr := c.LocalAddr
-	if localAddr == "" {
+	if _, ok := c.unixSocket(); localAddr == "" || ok {
 		localAddr = defaultLocalAddr
 	}
-	return fmt.Sprintf("http://localhost%s/callback", localAddr)
+	return fmt.Sprintf("http://localhost%s%s", localAddr, c.serverPath())
 }
 
-// ----------------------------------------------------------------------------
-// Helper functions
-// ----------------------------------------------------------------------------
-
-func openURL(url string) error {
-	switch os := runtime.GOOS; os {
-	case "windows":
-		return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
-	case "darwin":
-		return exec.Command("open", url).Start()
-	default: // "linux", "freebsd", "openbsd", "netbsd"
-		return exec.Command("xdg-open", url).Start()
-	}
+// unixSocket returns the path of the Unix socket set as LocalAddr, if any.
+func (c *Config) unixSocket() (string, bool) {
+	return strings.CutPrefix(c.LocalAddr, "unix:")
 }
 
-func store(fileName string, token *oauth2.Token) error {
-	f, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return err
+// isCallbackPath reports whether path is handled by the callback handler.
+func (c *Config) isCallbackPath(path string) bool {
+	if strings.HasSuffix(path, c.serverPath()) {
+		return true
 	}
-	defer f.Close()
-	return json.NewEncoder(f).Encode(token)
+	for _, redirectURL := range append([]string{c.RedirectURL}, c.RedirectURLs...) {
+		if u, err := url.Parse(redirectURL); err == nil && u.Path != "" && strings.HasSuffix(path, u.Path) {
+			return true
+		}
+	}
+	return false
 }
 
-func load(fileName string) (*oauth2.Token, error) {
-	f, err := os.Open(fileName)
-	if err != nil {
-		return nil, err
+func (c *Config) serverPath() string {
+	if c.ServerPath == "" {
+		return defaultServerPath
 	}
-	defer f.Close()
-	token := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(token)
-	return token, err
+	return c.ServerPath
 }
@@ -0,0 +1,307 @@
+package oauth2kit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// fakeProvider is a token endpoint issuing numbered tokens, valid for an
+// hour. Refresh tokens are rotated on each refresh if rotate is set, the
+// used ones being rejected with invalid_grant.
+type fakeProvider struct {
+	*httptest.Server
+
+	rotate bool
+
+	// respond, if set, answers the n-th request (from 1) instead.
+	respond func(n int, form url.Values) (status int, body map[string]any)
+
+	mu       sync.Mutex
+	requests []*http.Request
+	forms    []url.Values
+	refresh  string // the refresh token currently valid, with rotate
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+	p := &fakeProvider{}
+	p.Server = httptest.NewServer(http.HandlerFunc(p.serveToken))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *fakeProvider) serveToken(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests = append(p.requests, r)
+	p.forms = append(p.forms, r.PostForm)
+	n := len(p.forms)
+
+	status, body := http.StatusOK, map[string]any{
+		"access_token":  fmt.Sprintf("access-%d", n),
+		"token_type":    "Bearer",
+		"refresh_token": fmt.Sprintf("refresh-%d", n),
+		"expires_in":    3600,
+	}
+	if r.PostForm.Get("grant_type") == "refresh_token" && p.rotate {
+		if p.refresh != "" && r.PostForm.Get("refresh_token") != p.refresh {
+			status, body = http.StatusBadRequest, map[string]any{"error": "invalid_grant"}
+		} else {
+			p.refresh = body["refresh_token"].(string)
+		}
+	}
+	if p.respond != nil {
+		status, body = p.respond(n, r.PostForm)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// calls returns the number of requests received.
+func (p *fakeProvider) calls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.forms)
+}
+
+// form returns the form of the i-th request (from 0).
+func (p *fakeProvider) form(i int) url.Values {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.forms[i]
+}
+
+// manager returns a Manager of the provider, storing its token in a
+// temporary file and never opening a browser.
+func (p *fakeProvider) manager(t *testing.T) *Manager {
+	t.Helper()
+	return &Manager{
+		Writer: io.Discard,
+		Config: Config{
+			ClientID:     "client",
+			ClientSecret: "secret",
+			Scopes:       []string{"read"},
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   p.URL + "/auth",
+				TokenURL:  p.URL + "/token",
+				AuthStyle: oauth2.AuthStyleInParams,
+			},
+			TokenFile: filepath.Join(t.TempDir(), "token.json"),
+			Quiet:     true,
+			// Authorization flows fail right away unless a test sets a code
+			CodeReader: strings.NewReader(""),
+		},
+	}
+}
+
+// signIn makes the authorization flows of m run on a local server on a
+// free port, the browser being a client approving them with code.
+func signIn(t *testing.T, m *Manager, code string) {
+	t.Helper()
+	m.Config.CodeReader = nil
+	m.Config.LocalAddr = "localhost:0"
+	m.Config.BrowserOpener = BrowserOpenerFunc(func(authURL string) error {
+		u, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+		q := u.Query()
+		redirect, err := url.Parse(q.Get("redirect_uri"))
+		if err != nil {
+			return err
+		}
+		redirect.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+		resp, err := http.Get(redirect.String())
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	})
+}
+
+func TestAuthenticateParallel(t *testing.T) {
+	p := newFakeProvider(t)
+	managers := map[string]*Manager{"a": p.manager(t), "b": p.manager(t)}
+	for key, m := range managers {
+		signIn(t, m, "code-"+key)
+	}
+
+	results, err := AuthenticateParallel(context.Background(), managers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var codes []string
+	for i := range p.calls() {
+		codes = append(codes, p.form(i).Get("code"))
+	}
+	slices.Sort(codes)
+	if !slices.Equal(codes, []string{"code-a", "code-b"}) {
+		t.Errorf("exchanged codes %v, want one per manager", codes)
+	}
+	for key, m := range managers {
+		res := results[key]
+		if res == nil || !res.Interactive {
+			t.Fatalf("%s: result %+v, want an interactive authorization", key, res)
+		}
+		stored, err := m.tokenStore().Load(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		if stored.AccessToken != res.Token.AccessToken {
+			t.Errorf("%s: stored access token %q, want %q", key, stored.AccessToken, res.Token.AccessToken)
+		}
+	}
+	if results["a"].Token.AccessToken == results["b"].Token.AccessToken {
+		t.Errorf("both managers got the access token %q", results["a"].Token.AccessToken)
+	}
+}
+
+func TestAuthenticateParallelSharedTokenFile(t *testing.T) {
+	p := newFakeProvider(t)
+	a, b := p.manager(t), p.manager(t)
+	b.Config.TokenFile = a.Config.TokenFile
+	managers := map[string]*Manager{"a": a, "b": b}
+	if _, err := AuthenticateParallel(context.Background(), managers); err == nil {
+		t.Fatal("managers sharing a token file were accepted")
+	}
+	if n := p.calls(); n != 0 {
+		t.Errorf("%d token requests, want none", n)
+	}
+
+	// Distinct keys of a namespaced file are not shared
+	a.Config.NamespaceTokens, b.Config.NamespaceTokens = true, true
+	b.Config.Scopes = []string{"write"}
+	if err := checkSharedTokenFiles(managers); err != nil {
+		t.Error(err)
+	}
+}
+
+// requestedScopes records the scopes of each authorization flow of m,
+// whose BrowserOpener is set by signIn.
+func requestedScopes(m *Manager) *[]string {
+	var scopes []string
+	open := m.Config.BrowserOpener
+	m.Config.BrowserOpener = BrowserOpenerFunc(func(authURL string) error {
+		u, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+		scopes = append(scopes, u.Query().Get("scope"))
+		return open.Open(authURL)
+	})
+	return &scopes
+}
+
+func TestMaxTokenAgeKeepsGrantedScopes(t *testing.T) {
+	p := newFakeProvider(t)
+	m := p.manager(t)
+	signIn(t, m, "code")
+	requested := requestedScopes(m)
+	m.Config.MaxTokenAge = time.Hour
+	storeToken(t, m, (&oauth2.Token{
+		AccessToken: "old",
+		Expiry:      time.Now().Add(time.Hour),
+	}).WithExtra(map[string]any{
+		"scope":      "read write",
+		"granted_at": time.Now().Add(-2 * time.Hour).Format(time.RFC3339),
+	}))
+
+	if _, err := m.GetToken(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(*requested, []string{"read write"}) {
+		t.Errorf("requested scopes %q, want %q", *requested, "read write")
+	}
+}
+
+func TestLogsStructuredAttributes(t *testing.T) {
+	p := newFakeProvider(t)
+	p.respond = func(n int, form url.Values) (int, map[string]any) {
+		if n == 1 {
+			return http.StatusServiceUnavailable, map[string]any{"error": "temporarily_unavailable"}
+		}
+		return http.StatusOK, map[string]any{"access_token": "access", "token_type": "Bearer", "expires_in": 5}
+	}
+	m := p.manager(t)
+	m.Config.RetryPolicy = ExponentialRetry{Backoff: time.Millisecond}
+	var buf bytes.Buffer
+	ctx := WithLogger(context.Background(), slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	token := expiredToken("read")
+	token.Expiry = time.Now().Add(8 * time.Second) // the refreshed token expires earlier
+	if _, err := m.refresh(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+
+	records := make(map[string]map[string]any)
+	for line := range strings.Lines(buf.String()) {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		records[rec["msg"].(string)] = rec
+	}
+	for msg, attrs := range map[string][]string{
+		"Token refresh failed, retrying":                         {"attempt", "wait", "error"},
+		"Refreshed token expires no later than the previous one": {"expiry"},
+	} {
+		rec, ok := records[msg]
+		if !ok {
+			t.Errorf("no %q record in:\n%s", msg, buf.String())
+			continue
+		}
+		for _, attr := range attrs {
+			if _, ok := rec[attr]; !ok {
+				t.Errorf("%q record lacks the %q attribute: %v", msg, attr, rec)
+			}
+		}
+	}
+	for msg := range records {
+		if strings.Contains(msg, "temporarily_unavailable") {
+			t.Errorf("error concatenated to the message %q", msg)
+		}
+	}
+}
+
+func TestReuseNotifiedByGetTokenOnly(t *testing.T) {
+	p := newFakeProvider(t)
+	m := p.manager(t)
+	storeToken(t, m, &oauth2.Token{AccessToken: "access", Expiry: time.Now().Add(time.Hour)})
+	var reused int
+	m.Config.OnReuseToken = func(*oauth2.Token) { reused++ }
+	ctx := context.Background()
+
+	for range 3 {
+		if _, err := m.NewOAuth2Client(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reused != 0 {
+		t.Errorf("building clients notified %d reuses, want none", reused)
+	}
+	if _, err := m.GetToken(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Authenticate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if reused != 2 {
+		t.Errorf("GetToken and Authenticate notified %d reuses, want 2", reused)
+	}
+}
@@ -0,0 +1,166 @@
+package oauth2kit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// ProviderMetadata is the subset of an OpenID Provider's discovery document
+// (OpenID Connect Discovery 1.0, section 3) used by the Manager.
+type ProviderMetadata struct {
+	Issuer                      string   `json:"issuer"`
+	AuthorizationEndpoint       string   `json:"authorization_endpoint"`
+	TokenEndpoint               string   `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint string   `json:"device_authorization_endpoint,omitempty"`
+	RevocationEndpoint          string   `json:"revocation_endpoint,omitempty"`
+	IntrospectionEndpoint       string   `json:"introspection_endpoint,omitempty"`
+	JWKSURI                     string   `json:"jwks_uri,omitempty"`
+	UserInfoEndpoint            string   `json:"userinfo_endpoint,omitempty"`
+	ScopesSupported             []string `json:"scopes_supported,omitempty"`
+
+	PushedAuthorizationRequestEndpoint string `json:"pushed_authorization_request_endpoint,omitempty"`
+	RequirePushedAuthorizationRequests bool   `json:"require_pushed_authorization_requests,omitempty"`
+}
+
+// discoveryCache holds the discovery documents fetched by DiscoverProvider,
+// keyed by issuer.
+var discoveryCache sync.Map
+
+// DiscoverProvider fetches the discovery document of issuer from its
+// "/.well-known/openid-configuration" path, with the client ctx carries
+// under oauth2.HTTPClient, as golang.org/x/oauth2 does, or else
+// http.DefaultClient. Documents are cached for the lifetime of the process.
+func DiscoverProvider(ctx context.Context, issuer string) (*ProviderMetadata, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+	if md, ok := discoveryCache.Load(issuer); ok {
+		return md.(*ProviderMetadata), nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	client := http.DefaultClient
+	if c, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok && c != nil {
+		client = c
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovery endpoint returned %s", resp.Status)
+	}
+
+	md := &ProviderMetadata{}
+	if err := json.Unmarshal(body, md); err != nil {
+		return nil, fmt.Errorf("decode discovery document: %w", err)
+	}
+	// The issuer must match exactly to prevent impersonation (section 4.3)
+	if strings.TrimSuffix(md.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("discovery document issuer %q does not match %q", md.Issuer, issuer)
+	}
+	discoveryCache.Store(issuer, md)
+	return md, nil
+}
+
+// NewOIDCManager returns a Manager for an OpenID Connect provider, with the
+// endpoints of Config (authorization, token, device authorization,
+// revocation, introspection, JWKS and userinfo) set from the provider's
+// discovery document. The "openid" scope is added if missing.
+//
+// Tokens are stored under os.UserConfigDir, namespaced by Manager.TokenKey,
+// or in the default token file if there is no user configuration directory.
+func NewOIDCManager(ctx context.Context, issuer, clientID, clientSecret string, scopes ...string) (*Manager, error) {
+	md, err := DiscoverProvider(ctx, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discover %s: %w", issuer, err)
+	}
+	if !slices.Contains(scopes, "openid") {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+
+	cfg := Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:       md.AuthorizationEndpoint,
+			TokenURL:      md.TokenEndpoint,
+			DeviceAuthURL: md.DeviceAuthorizationEndpoint,
+		},
+		RevocationURL:    md.RevocationEndpoint,
+		IntrospectionURL: md.IntrospectionEndpoint,
+		JWKSURL:          md.JWKSURI,
+		UserInfoURL:      md.UserInfoEndpoint,
+		ExpectedIssuer:   md.Issuer,
+		ExpectedAudience: clientID,
+	}
+	if md.RequirePushedAuthorizationRequests {
+		cfg.PAREndpoint = md.PushedAuthorizationRequestEndpoint
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		dir = filepath.Join(dir, "oauth2kit")
+		if err := os.MkdirAll(dir, 0700); err == nil {
+			cfg.TokenFile = filepath.Join(dir, "tokens.json")
+			cfg.NamespaceTokens = true
+		}
+	}
+	return &Manager{Config: cfg}, nil
+}
+
+// UserInfo fetches the claims of the authenticated user from
+// Config.UserInfoURL, with the client NewOAuth2Client returns. The
+// response is returned too, for inspecting its status and headers, such as
+// rate limits; its body has been read and closed, and is replaced with a
+// copy of the content.
+func (m *Manager) UserInfo(ctx context.Context) (map[string]any, *http.Response, error) {
+	if m.Config.UserInfoURL == "" {
+		return nil, nil, errors.New("no userinfo endpoint configured")
+	}
+	client, err := m.NewOAuth2Client(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.Config.UserInfoURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return nil, resp, fmt.Errorf("read userinfo response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp, fmt.Errorf("userinfo endpoint returned %s: %s", resp.Status, body)
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return nil, resp, fmt.Errorf("decode userinfo response: %w", err)
+	}
+	return claims, resp, nil
+}
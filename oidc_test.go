@@ -0,0 +1,53 @@
+package oauth2kit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// rewriteTransport sends every request to target instead of its host.
+type rewriteTransport struct{ target *url.URL }
+
+func (t rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.URL.Scheme, r.URL.Host = t.target.Scheme, t.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestDiscoverProviderUsesContextClientAndCache(t *testing.T) {
+	const issuer = "https://discovery-cache.example"
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path != "/.well-known/openid-configuration" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(ProviderMetadata{Issuer: issuer, TokenEndpoint: issuer + "/token"})
+	}))
+	defer srv.Close()
+	target, _ := url.Parse(srv.URL)
+
+	// The issuer's host only resolves through the client of the context
+	client := &http.Client{Transport: rewriteTransport{target}}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, client)
+	for range 2 {
+		md, err := DiscoverProvider(ctx, issuer+"/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if md.TokenEndpoint != issuer+"/token" {
+			t.Errorf("token endpoint %q, want %q", md.TokenEndpoint, issuer+"/token")
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d discovery requests, want 1", n)
+	}
+}
@@ -0,0 +1,68 @@
+package oauth2kit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// defaultPARExpiresIn is the lifetime assumed for a pushed authorization
+// request when the provider does not return one.
+const defaultPARExpiresIn = 60 * time.Second
+
+// pushAuthRequest pushes the authorization request of conf to
+// Config.PAREndpoint (RFC 9126) and returns the authorization URL
+// referencing it, with its expiry.
+func (m *Manager) pushAuthRequest(ctx context.Context, conf *oauth2.Config, state string, opts []oauth2.AuthCodeOption) (string, time.Time, error) {
+	// The parameters are those golang.org/x/oauth2 would put in the URL
+	u, err := url.Parse(conf.AuthCodeURL(state, opts...))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("build authorization request: %w", err)
+	}
+	resp, body, err := m.postClientForm(m.oauth2Context(ctx), m.Config.PAREndpoint, u.Query())
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("pushed authorization request: %w", err)
+	}
+	var result struct {
+		RequestURI       string `json:"request_uri"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	jsonErr := json.Unmarshal(body, &result)
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		if result.Error != "" {
+			return "", time.Time{}, fmt.Errorf("pushed authorization request: %s: %s", result.Error, result.ErrorDescription)
+		}
+		return "", time.Time{}, fmt.Errorf("pushed authorization request: endpoint returned %s: %s", resp.Status, body)
+	}
+	if jsonErr != nil {
+		return "", time.Time{}, fmt.Errorf("decode pushed authorization response: %w", jsonErr)
+	}
+	if result.RequestURI == "" {
+		return "", time.Time{}, errors.New("pushed authorization request: no request_uri returned")
+	}
+
+	expiresIn := defaultPARExpiresIn
+	if result.ExpiresIn > 0 {
+		expiresIn = time.Duration(result.ExpiresIn) * time.Second
+	}
+	q := url.Values{
+		"client_id":   {m.Config.ClientID},
+		"request_uri": {result.RequestURI},
+	}
+	authURL := m.Config.Endpoint.AuthURL
+	if strings.Contains(authURL, "?") {
+		authURL += "&"
+	} else {
+		authURL += "?"
+	}
+	return authURL + q.Encode(), m.now().Add(expiresIn), nil
+}
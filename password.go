@@ -0,0 +1,41 @@
+package oauth2kit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrPasswordGrantDisabled is returned by PasswordToken unless
+// Config.AllowPasswordGrant is set.
+var ErrPasswordGrantDisabled = errors.New("password grant is disabled")
+
+// PasswordToken obtains a token for Config.Scopes with the resource owner
+// password credentials grant (RFC 6749, section 4.3) and persists it.
+//
+// The grant exposes the user's password to the application and is
+// deprecated by OAuth 2.0 Security Best Current Practice. It is only meant
+// for trusted first-party applications, such as legacy automation against
+// an identity provider of your own, and requires Config.AllowPasswordGrant.
+// Prefer GetToken or DeviceFlow whenever a user can sign in interactively.
+func (m *Manager) PasswordToken(ctx context.Context, username, password string) (*oauth2.Token, error) {
+	if !m.Config.AllowPasswordGrant {
+		return nil, ErrPasswordGrantDisabled
+	}
+	conf := m.oauth2ConfigOAuth2()
+	token, err := m.withRetry(ctx, "Password grant", func() (*oauth2.Token, error) {
+		return conf.PasswordCredentialsToken(m.oauth2Context(ctx), username, password)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("password grant: %w", asTokenError(err))
+	}
+	if token, err = m.acceptToken(ctx, token, m.Config.Scopes); err != nil {
+		return nil, err
+	}
+	if err := m.saveToken(ctx, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
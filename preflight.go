@@ -0,0 +1,111 @@
+package oauth2kit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Preflight checks the configuration without running an authorization
+// flow, e.g. in CI before shipping: the endpoints and redirect URIs must be
+// valid URLs and the provider's hosts must resolve. With
+// Config.ExpectedIssuer, the endpoints are also compared with the
+// provider's discovery document.
+//
+// Common misconfigurations that do not prevent the flow, such as not
+// requesting offline access, are logged as warnings. All errors found are
+// returned, joined.
+func (m *Manager) Preflight(ctx context.Context) error {
+	logger := m.logger(ctx)
+	cfg := m.Config
+	var errs []error
+
+	if cfg.ClientID == "" {
+		errs = append(errs, errors.New("ClientID is empty"))
+	}
+	if n := cfg.PKCEVerifierLength; n != 0 && (n < minVerifierLength || n > maxVerifierLength) {
+		errs = append(errs, fmt.Errorf("PKCEVerifierLength %d is out of the %d-%d range", n, minVerifierLength, maxVerifierLength))
+	}
+	if err := cfg.checkImplicit(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(cfg.Scopes) == 0 {
+		logger.Warn("No scopes configured; most providers require at least one")
+	}
+
+	hosts := make(map[string]bool)
+	for name, endpoint := range map[string]string{
+		"Endpoint.AuthURL":  cfg.Endpoint.AuthURL,
+		"Endpoint.TokenURL": cfg.Endpoint.TokenURL,
+	} {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+			errs = append(errs, fmt.Errorf("%s: invalid URL %q", name, endpoint))
+			continue
+		}
+		if u.Scheme == "http" && !isLoopback(u.Hostname()) {
+			logger.Warn("Endpoint does not use HTTPS", slog.String("endpoint", name), slog.String("url", endpoint))
+		}
+		hosts[u.Hostname()] = true
+	}
+	for host := range hosts {
+		if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+			errs = append(errs, fmt.Errorf("resolve %s: %w", host, err))
+		}
+	}
+
+	if _, unix := cfg.unixSocket(); cfg.LocalAddr != "" && !unix && !strings.HasPrefix(cfg.LocalAddr, ":") && (len(cfg.RedirectURLs) == 0 || cfg.RedirectURL != "") {
+		errs = append(errs, fmt.Errorf("LocalAddr %q: only a port (e.g. \":15440\") is supported; use RedirectURLs for another host", cfg.LocalAddr))
+	}
+	redirects := cfg.RedirectURLs
+	if len(redirects) == 0 || cfg.RedirectURL != "" {
+		redirects = []string{cfg.buildRedirectURL()}
+	}
+	for _, redirectURL := range redirects {
+		u, err := url.Parse(redirectURL)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			errs = append(errs, fmt.Errorf("invalid redirect URI %q", redirectURL))
+			continue
+		}
+		if u.Hostname() == "127.0.0.1" || u.Hostname() == "::1" {
+			logger.Warn("Redirect URI must be registered as is: providers tell localhost and loopback addresses apart",
+				slog.String("redirect_uri", redirectURL))
+		}
+	}
+
+	offline := cfg.AccessType == "offline" || (cfg.AccessType == "" && cfg.offline())
+	if cfg.ResponseType != ResponseTypeToken && !offline && !slices.Contains(cfg.Scopes, "offline_access") {
+		logger.Warn("Neither offline access nor the \"offline_access\" scope is requested; the provider may not issue a refresh token")
+	}
+
+	if cfg.ExpectedIssuer != "" {
+		md, err := DiscoverProvider(m.oauth2Context(ctx), cfg.ExpectedIssuer)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("discover %s: %w", cfg.ExpectedIssuer, err))
+		} else {
+			if md.AuthorizationEndpoint != cfg.Endpoint.AuthURL {
+				logger.Warn("Endpoint.AuthURL differs from the provider's authorization_endpoint",
+					slog.String("authorization_endpoint", md.AuthorizationEndpoint))
+			}
+			if md.TokenEndpoint != cfg.Endpoint.TokenURL {
+				logger.Warn("Endpoint.TokenURL differs from the provider's token_endpoint",
+					slog.String("token_endpoint", md.TokenEndpoint))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// isLoopback reports whether host designates the local machine.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,303 @@
+// Package qrcode encodes text as a QR code (ISO/IEC 18004) and renders it
+// on a terminal.
+//
+// It implements just what is needed to display URLs: byte mode, error
+// correction level L and automatic version and mask selection. It has no
+// dependencies outside the standard library.
+package qrcode
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+// ErrTooLong is returned when the text does not fit in the largest QR code.
+var ErrTooLong = errors.New("qrcode: text too long")
+
+// Code is an encoded QR code.
+type Code struct {
+	size    int
+	modules [][]bool // [y][x], true for dark
+}
+
+// Encode encodes text as a QR code, using the smallest version that fits.
+func Encode(text string) (*Code, error) {
+	return encode(text, -1)
+}
+
+// encode encodes text with mask, or with the mask of the lowest penalty if
+// mask is negative.
+func encode(text string, mask int) (*Code, error) {
+	data := []byte(text)
+	version := 0
+	for v := minVersion; v <= maxVersion; v++ {
+		if dataBits(v, len(data)) <= numDataCodewords(v)*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	// Segment: byte mode indicator, character count and data
+	var bb bitBuffer
+	bb.append(0x4, 4)
+	bb.append(len(data), countBits(version))
+	for _, b := range data {
+		bb.append(int(b), 8)
+	}
+
+	// Terminator, byte alignment and alternating pad bytes
+	capacity := numDataCodewords(version) * 8
+	bb.append(0, min(4, capacity-len(bb)))
+	bb.append(0, (8-len(bb)%8)%8)
+	for pad := 0xEC; len(bb) < capacity; pad ^= 0xEC ^ 0x11 {
+		bb.append(pad, 8)
+	}
+
+	codewords := make([]byte, len(bb)/8)
+	for i, bit := range bb {
+		if bit {
+			codewords[i>>3] |= 1 << (7 - i&7)
+		}
+	}
+
+	q := newQR(version)
+	q.drawFunctionPatterns()
+	q.drawCodewords(addECCAndInterleave(codewords, version))
+
+	// Pick the mask with the lowest penalty
+	if mask < 0 {
+		bestPenalty := -1
+		for m := 0; m < 8; m++ {
+			q.applyMask(m)
+			q.drawFormatBits(m)
+			if p := q.penalty(); bestPenalty < 0 || p < bestPenalty {
+				mask, bestPenalty = m, p
+			}
+			q.applyMask(m) // XOR again to undo
+		}
+	}
+	q.applyMask(mask)
+	q.drawFormatBits(mask)
+
+	return &Code{size: q.size, modules: q.modules}, nil
+}
+
+// Size returns the number of modules on each side of the code, excluding
+// the quiet zone.
+func (c *Code) Size() int {
+	return c.size
+}
+
+// Dark reports whether the module at column x and row y is dark. Modules
+// outside the code are light.
+func (c *Code) Dark(x, y int) bool {
+	return x >= 0 && y >= 0 && x < c.size && y < c.size && c.modules[y][x]
+}
+
+// quietZone is the width of the light border around the code, in modules.
+const quietZone = 2
+
+// WriteTerminal renders the code with Unicode half blocks, each character
+// covering two rows of modules. Light modules are drawn with block
+// characters, so the code reads correctly on terminals with a dark
+// background.
+func (c *Code) WriteTerminal(w io.Writer) error {
+	var sb strings.Builder
+	for y := -quietZone; y < c.size+quietZone; y += 2 {
+		for x := -quietZone; x < c.size+quietZone; x++ {
+			top, bottom := !c.Dark(x, y), !c.Dark(x, y+1)
+			if y+1 >= c.size+quietZone {
+				bottom = false
+			}
+			switch {
+			case top && bottom:
+				sb.WriteString("█")
+			case top:
+				sb.WriteString("▀")
+			case bottom:
+				sb.WriteString("▄")
+			default:
+				sb.WriteString(" ")
+			}
+		}
+		sb.WriteString("\n")
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// ----------------------------------------------------------------------------
+// Capacity
+// ----------------------------------------------------------------------------
+
+const (
+	minVersion = 1
+	maxVersion = 40
+)
+
+// Error correction codewords per block and number of blocks, indexed by
+// version, for error correction level L.
+var (
+	eccCodewordsPerBlock = [maxVersion + 1]int{-1,
+		7, 10, 15, 20, 26, 18, 20, 24, 30, 18,
+		20, 24, 26, 30, 22, 24, 28, 30, 28, 28,
+		28, 28, 30, 30, 26, 28, 30, 30, 30, 30,
+		30, 30, 30, 30, 30, 30, 30, 30, 30, 30}
+	numErrorCorrectionBlocks = [maxVersion + 1]int{-1,
+		1, 1, 1, 1, 1, 2, 2, 2, 2, 4,
+		4, 4, 4, 4, 6, 6, 6, 6, 7, 8,
+		8, 9, 9, 10, 12, 12, 12, 13, 14, 15,
+		16, 17, 18, 19, 19, 20, 21, 22, 24, 25}
+)
+
+// countBits returns the width of the byte mode character count.
+func countBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+// dataBits returns the number of bits of a byte mode segment of n bytes.
+func dataBits(version, n int) int {
+	return 4 + countBits(version) + 8*n
+}
+
+// numRawDataModules returns the number of modules available for data and
+// error correction codewords, i.e. not used by function patterns.
+func numRawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		result -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+// numDataCodewords returns the number of data codewords of a version.
+func numDataCodewords(version int) int {
+	return numRawDataModules(version)/8 - eccCodewordsPerBlock[version]*numErrorCorrectionBlocks[version]
+}
+
+// alignmentPatternPositions returns the coordinates of the centers of the
+// alignment patterns, on both axes.
+func alignmentPatternPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	numAlign := version/7 + 2
+	step := (version*4 + numAlign*2 + 1) / (numAlign*2 - 2) * 2
+	if version == 32 {
+		step = 26
+	}
+	size := version*4 + 17
+	result := make([]int, numAlign)
+	result[0] = 6
+	for i, pos := numAlign-1, size-7; i >= 1; i, pos = i-1, pos-step {
+		result[i] = pos
+	}
+	return result
+}
+
+// ----------------------------------------------------------------------------
+// Error correction
+// ----------------------------------------------------------------------------
+
+// addECCAndInterleave splits the data codewords into blocks, appends the
+// Reed-Solomon error correction codewords of each block and interleaves
+// the blocks.
+func addECCAndInterleave(data []byte, version int) []byte {
+	numBlocks := numErrorCorrectionBlocks[version]
+	blockECCLen := eccCodewordsPerBlock[version]
+	rawCodewords := numRawDataModules(version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+
+	divisor := reedSolomonDivisor(blockECCLen)
+	blocks := make([][]byte, numBlocks)
+	for i, k := 0, 0; i < numBlocks; i++ {
+		datLen := shortBlockLen - blockECCLen
+		if i >= numShortBlocks {
+			datLen++
+		}
+		dat := data[k : k+datLen]
+		k += datLen
+		block := make([]byte, 0, shortBlockLen+1)
+		block = append(block, dat...)
+		if i < numShortBlocks {
+			block = append(block, 0) // Padding, skipped when interleaving
+		}
+		blocks[i] = append(block, reedSolomonRemainder(dat, divisor)...)
+	}
+
+	result := make([]byte, 0, rawCodewords)
+	for i := 0; i < len(blocks[0]); i++ {
+		for j, block := range blocks {
+			if i != shortBlockLen-blockECCLen || j >= numShortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// reedSolomonDivisor returns the generator polynomial of the given degree,
+// highest coefficient first and the leading 1 omitted.
+func reedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// reedSolomonRemainder returns the error correction codewords of data.
+func reedSolomonRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMultiply(d, factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies two elements of GF(2^8) modulo x^8+x^4+x^3+x^2+1.
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+// ----------------------------------------------------------------------------
+// Helper types
+// ----------------------------------------------------------------------------
+
+type bitBuffer []bool
+
+func (bb *bitBuffer) append(value, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*bb = append(*bb, (value>>i)&1 != 0)
+	}
+}
@@ -0,0 +1,308 @@
+package qrcode
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Reference values of ISO/IEC 18004, for error correction level L.
+var (
+	// byteCapacity is the number of bytes a byte mode segment holds, by
+	// version.
+	byteCapacity = [maxVersion + 1]int{0,
+		17, 32, 53, 78, 106, 134, 154, 192, 230, 271,
+		321, 367, 425, 458, 520, 586, 644, 718, 792, 858,
+		929, 1003, 1091, 1171, 1273, 1367, 1465, 1528, 1628, 1732,
+		1840, 1952, 2068, 2188, 2303, 2431, 2563, 2699, 2809, 2953}
+
+	// formatInfo is the masked format information, by mask.
+	formatInfo = [8]int{0x77C4, 0x72F3, 0x7DAA, 0x789D, 0x662F, 0x6318, 0x6C41, 0x6976}
+
+	// versionInfo is the version information of a few versions.
+	versionInfo = map[int]int{7: 0x07C94, 8: 0x085BC, 9: 0x09A99, 10: 0x0A4D3, 40: 0x28C69}
+
+	// alignmentCenters is the alignment pattern coordinates of a few
+	// versions.
+	alignmentCenters = map[int][]int{
+		1:  nil,
+		2:  {6, 18},
+		7:  {6, 22, 38},
+		14: {6, 26, 46, 66},
+		32: {6, 34, 60, 86, 112, 138},
+		40: {6, 30, 58, 86, 114, 142, 170},
+	}
+)
+
+func TestEncodeDecodes(t *testing.T) {
+	for _, tc := range []struct {
+		version int
+		text    string
+	}{
+		{1, "https://a.example"},
+		{2, "https://example.com/device"},
+		{7, "https://login.example.com/device?user_code=" + strings.Repeat("WDJB-MJHT", 11)},
+		{10, strings.Repeat("0123456789", 26)},
+		{40, strings.Repeat("x", byteCapacity[40])},
+	} {
+		for mask := range 8 {
+			c, err := encode(tc.text, mask)
+			if err != nil {
+				t.Fatalf("version %d, mask %d: %v", tc.version, mask, err)
+			}
+			if want := tc.version*4 + 17; c.Size() != want {
+				t.Fatalf("version %d: size %d, want %d", tc.version, c.Size(), want)
+			}
+			text, gotMask, err := decode(c)
+			if err != nil {
+				t.Errorf("version %d, mask %d: %v", tc.version, mask, err)
+				continue
+			}
+			if gotMask != mask || text != tc.text {
+				t.Errorf("version %d, mask %d: decoded %q with mask %d", tc.version, mask, text, gotMask)
+			}
+		}
+	}
+}
+
+func TestVersionInformation(t *testing.T) {
+	for version, want := range versionInfo {
+		c, err := encode(strings.Repeat("x", byteCapacity[version]), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var below, right int
+		for i := range 18 {
+			a, b := c.size-11+i%3, i/3
+			if c.Dark(b, a) {
+				below |= 1 << i
+			}
+			if c.Dark(a, b) {
+				right |= 1 << i
+			}
+		}
+		if below != want || right != want {
+			t.Errorf("version %d: version information %#x and %#x, want %#x", version, below, right, want)
+		}
+	}
+}
+
+func TestAlignmentPatternPositions(t *testing.T) {
+	for version, want := range alignmentCenters {
+		if got := alignmentPatternPositions(version); !slices.Equal(got, want) {
+			t.Errorf("version %d: %v, want %v", version, got, want)
+		}
+	}
+}
+
+func TestReedSolomon(t *testing.T) {
+	// Data and error correction codewords of "HELLO WORLD" as version 1-M
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := reedSolomonRemainder(data, reedSolomonDivisor(len(want))); !slices.Equal(got, want) {
+		t.Errorf("error correction codewords %v, want %v", got, want)
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	for version := minVersion; version <= maxVersion; version++ {
+		n := byteCapacity[version]
+		c, err := Encode(strings.Repeat("x", n))
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if want := version*4 + 17; c.Size() != want {
+			t.Errorf("%d bytes: size %d, want %d (version %d)", n, c.Size(), want, version)
+		}
+		c, err = Encode(strings.Repeat("x", n+1))
+		if version == maxVersion {
+			if !errors.Is(err, ErrTooLong) {
+				t.Errorf("%d bytes: got %v, want ErrTooLong", n+1, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n+1, err)
+		}
+		if want := (version+1)*4 + 17; c.Size() != want {
+			t.Errorf("%d bytes: size %d, want %d (version %d)", n+1, c.Size(), want, version+1)
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Decoder
+// ----------------------------------------------------------------------------
+
+// decode reads a byte mode, level L code back, checking its format
+// information against the reference values and its error correction
+// codewords with syndromes, independently of the encoder's arithmetic.
+func decode(c *Code) (text string, mask int, err error) {
+	version := (c.size - 17) / 4
+
+	var format int
+	for i := range 15 {
+		var x, y int
+		switch {
+		case i <= 5:
+			x, y = 8, i
+		case i == 6:
+			x, y = 8, 7
+		case i == 7:
+			x, y = 8, 8
+		case i == 8:
+			x, y = 7, 8
+		default:
+			x, y = 14-i, 8
+		}
+		if c.Dark(x, y) {
+			format |= 1 << i
+		}
+	}
+	mask = slices.Index(formatInfo[:], format)
+	if mask < 0 {
+		return "", 0, errors.New("unknown format information")
+	}
+
+	// Data modules are those left free by the function patterns
+	q := newQR(version)
+	q.drawFunctionPatterns()
+	var bits []bool
+	for right := c.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := range c.size {
+			for j := range 2 {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.size - 1 - vert
+				}
+				if !q.isFunction[y][x] {
+					bits = append(bits, c.Dark(x, y) != masked(mask, x, y))
+				}
+			}
+		}
+	}
+	codewords := make([]byte, len(bits)/8)
+	for i := range codewords {
+		for _, b := range bits[i*8 : i*8+8] {
+			codewords[i] <<= 1
+			if b {
+				codewords[i] |= 1
+			}
+		}
+	}
+
+	// De-interleave the blocks; the short ones come first
+	numBlocks := numErrorCorrectionBlocks[version]
+	eccLen := eccCodewordsPerBlock[version]
+	numLong := len(codewords) % numBlocks
+	shortLen := len(codewords) / numBlocks
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := range shortLen - eccLen + 1 {
+		for j := range blocks {
+			if i < shortLen-eccLen || j >= numBlocks-numLong {
+				blocks[j] = append(blocks[j], codewords[k])
+				k++
+			}
+		}
+	}
+	for range eccLen {
+		for j := range blocks {
+			blocks[j] = append(blocks[j], codewords[k])
+			k++
+		}
+	}
+	var data []byte
+	for _, block := range blocks {
+		if !syndromesZero(block, eccLen) {
+			return "", 0, errors.New("error correction codewords do not match")
+		}
+		data = append(data, block[:len(block)-eccLen]...)
+	}
+
+	// Byte mode segment, then the terminator and pad bytes
+	r := bitReader{data: data}
+	if r.read(4) != 0x4 {
+		return "", 0, errors.New("not a byte mode segment")
+	}
+	b := make([]byte, r.read(countBits(version)))
+	for i := range b {
+		b[i] = byte(r.read(8))
+	}
+	for i, pad := range data[min((r.pos+4+7)/8, len(data)):] {
+		if want := [2]byte{0xEC, 0x11}[i%2]; pad != want {
+			return "", 0, fmt.Errorf("pad byte %d is %#x, want %#x", i, pad, want)
+		}
+	}
+	return string(b), mask, nil
+}
+
+// masked reports whether mask inverts the module at x, y.
+func masked(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (y+x)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (y+x)%3 == 0
+	case 4:
+		return (y/2+x/3)%2 == 0
+	case 5:
+		return (y*x)%2+(y*x)%3 == 0
+	case 6:
+		return ((y*x)%2+(y*x)%3)%2 == 0
+	default:
+		return ((y+x)%2+(y*x)%3)%2 == 0
+	}
+}
+
+// syndromesZero reports whether block, codewords of a Reed-Solomon code
+// with eccLen error correction codewords, is error free: the polynomial it
+// forms has the roots α^0 to α^(eccLen-1).
+func syndromesZero(block []byte, eccLen int) bool {
+	var exp [255]byte
+	var log [256]int
+	for i, x := 0, 1; i < 255; i++ {
+		exp[i] = byte(x)
+		log[x] = i
+		if x <<= 1; x >= 256 {
+			x ^= 0x11D
+		}
+	}
+	for i := range eccLen {
+		var s byte
+		for _, b := range block {
+			// s = s*α^i + b
+			if s != 0 {
+				s = exp[(log[s]+i)%255]
+			}
+			s ^= b
+		}
+		if s != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bitReader) read(n int) int {
+	v := 0
+	for range n {
+		v = v<<1 | int(r.data[r.pos/8]>>(7-r.pos%8)&1)
+		r.pos++
+	}
+	return v
+}
@@ -0,0 +1,71 @@
+package oauth2kit
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// reauthTransport retries a request once with a new token when the server
+// answers 401 Unauthorized, e.g. because the token was revoked server-side.
+// It is used with Config.ReauthOn401.
+type reauthTransport struct {
+	src  *persistingTokenSource
+	base http.RoundTripper // authorizes requests with src
+}
+
+func (t *reauthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	// Only requests whose body can be sent again are retried
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+	if err := t.src.reauthorize(); err != nil {
+		t.src.m.logger(t.src.ctx).Warn("Re-authorization after 401 failed", slog.Any("error", err))
+		return resp, nil
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	resp.Body.Close()
+	return t.base.RoundTrip(retry)
+}
+
+// reauthorize replaces the token rejected by the server: it is refreshed
+// if possible, else a new authorization flow is run.
+func (s *persistingTokenSource) reauthorize() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.m
+	logger := m.logger(s.ctx)
+	rejected := s.token
+
+	if rejected.RefreshToken != "" {
+		token, err := m.refresh(s.ctx, rejected)
+		if err == nil && token.AccessToken != rejected.AccessToken {
+			s.token = token
+			return nil
+		}
+	}
+
+	logger.Info("Token rejected by the server, re-authorizing")
+	if err := m.tokenStore().Delete(s.ctx); err != nil {
+		logger.Warn("Failed to delete rejected token", slog.Any("error", err))
+	}
+	token, err := m.authorizeAndSave(s.ctx, m.reauthScopes(rejected))
+	if err != nil {
+		return err
+	}
+	s.token = token
+	return nil
+}
@@ -0,0 +1,331 @@
+package oauth2kit
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	defaultExpiryDelta   = 10 * time.Second
+	defaultExpiryWarning = 5 * time.Minute
+
+	// staleRefreshBackoff is how long refreshes are suspended after one
+	// returned a token whose expiry did not advance, while the current
+	// token has not actually expired, to avoid tight refresh loops.
+	staleRefreshBackoff = 30 * time.Second
+)
+
+// ErrStaleRefresh is returned when a refresh yields a token expiring no
+// later than the token it replaces and Config.RejectStaleRefresh is set.
+var ErrStaleRefresh = errors.New("refreshed token does not expire later than the previous one")
+
+// ErrRefreshTokenExpired is returned when the provider rejects the refresh
+// token with invalid_grant, because it has expired or was revoked: the user
+// must sign in again. The *TokenError remains reachable with errors.As.
+var ErrRefreshTokenExpired = errors.New("refresh token expired or revoked")
+
+// persistingTokenSource is the token source of the clients returned by
+// NewOAuth2Client. It reuses its token until it expires; refreshed tokens
+// are persisted to the token store.
+type persistingTokenSource struct {
+	ctx context.Context
+	m   *Manager
+
+	mu     sync.Mutex
+	token  *oauth2.Token
+	warned string // access token Config.OnExpiryWarning was fired for
+}
+
+func (s *persistingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.m.TokenUsable(s.token) {
+		res, err := s.m.renew(s.ctx, s.token)
+		if err != nil {
+			return nil, err
+		}
+		s.token = res.Token
+	}
+	s.warnExpiry()
+	return s.token, nil
+}
+
+// warnExpiry fires Config.OnExpiryWarning, once per token, when a token
+// which cannot be refreshed is about to expire.
+func (s *persistingTokenSource) warnExpiry() {
+	cfg := s.m.Config
+	t := s.token
+	if cfg.OnExpiryWarning == nil || t.RefreshToken != "" || t.Expiry.IsZero() || s.warned == t.AccessToken {
+		return
+	}
+	if remaining := t.Expiry.Sub(s.m.now()); remaining <= cmp.Or(cfg.ExpiryWarning, defaultExpiryWarning) {
+		s.warned = t.AccessToken
+		cfg.OnExpiryWarning(remaining)
+	}
+}
+
+// now returns the current time of Config.Clock.
+func (m *Manager) now() time.Time {
+	if m.Config.Clock != nil {
+		return m.Config.Clock()
+	}
+	return time.Now()
+}
+
+// tokenTooOld reports whether the authorization behind token is older than
+// Config.MaxTokenAge.
+func (m *Manager) tokenTooOld(token *oauth2.Token) bool {
+	if m.Config.MaxTokenAge <= 0 {
+		return false
+	}
+	s, _ := token.Extra("granted_at").(string)
+	grantedAt, err := time.Parse(time.RFC3339, s)
+	return err != nil || m.now().Sub(grantedAt) > m.Config.MaxTokenAge
+}
+
+// TokenUsable reports whether token can be used as is, the decision every
+// code path of the Manager makes before refreshing a token: it must have
+// an access token, and its expiry, if any, must be later than the current
+// time of Config.Clock plus Config.ExpiryDelta minus Config.ClockSkew.
+// Tokens without expiry are always usable.
+func (m *Manager) TokenUsable(token *oauth2.Token) bool {
+	if token == nil || token.AccessToken == "" {
+		return false
+	}
+	if token.Expiry.IsZero() {
+		return true
+	}
+	delta := cmp.Or(m.Config.ExpiryDelta, defaultExpiryDelta)
+	return m.now().Add(delta - m.Config.ClockSkew).Before(token.Expiry)
+}
+
+// renew refreshes token. With Config.ReauthOnInvalidGrant, a refresh token
+// rejected as expired or revoked is discarded and a new authorization flow
+// is run instead.
+func (m *Manager) renew(ctx context.Context, token *oauth2.Token) (*TokenResult, error) {
+	refreshed, err := m.refresh(ctx, token)
+	if err == nil {
+		return &TokenResult{Token: refreshed, Refreshed: true}, nil
+	}
+	if !m.Config.ReauthOnInvalidGrant || !errors.Is(err, ErrRefreshTokenExpired) {
+		return nil, err
+	}
+	logger := m.logger(ctx)
+	logger.Info("Refresh token expired or revoked, re-authorizing")
+	if err := m.tokenStore().Delete(ctx); err != nil {
+		logger.Warn("Failed to delete expired token", slog.Any("error", err))
+	}
+	if token, err = m.authorizeAndSave(ctx, m.reauthScopes(token)); err != nil {
+		return nil, err
+	}
+	return &TokenResult{Token: token, Interactive: true}, nil
+}
+
+// refreshCall is a refresh shared by every goroutine needing one while it
+// is in flight.
+type refreshCall struct {
+	done   chan struct{}
+	scoped bool // down-scoped refresh of RefreshWithScopes
+	token  *oauth2.Token
+	err    error
+}
+
+// refresh obtains a new token using the refresh token of token and persists
+// it. Concurrent calls share a single request to the token endpoint, which
+// matters with providers rotating refresh tokens: a second request with the
+// same refresh token would be rejected.
+func (m *Manager) refresh(ctx context.Context, token *oauth2.Token) (*oauth2.Token, error) {
+	return m.sharedRefresh(ctx, token, nil)
+}
+
+// sharedRefresh runs the refresh of token, down-scoped to scopes if not
+// nil, once no other refresh is in flight. Refreshes for the same scopes
+// share the result of the one in flight; others wait for it, then use the
+// refresh token it may have rotated.
+func (m *Manager) sharedRefresh(ctx context.Context, token *oauth2.Token, scopes []string) (*oauth2.Token, error) {
+	if m.Config.LocalOnly {
+		return nil, fmt.Errorf("%w at %s; refreshing is disabled by LocalOnly", ErrTokenExpired, token.Expiry.Format(time.RFC3339))
+	}
+	m.mu.Lock()
+	for {
+		if now := m.now(); scopes == nil && now.Before(m.refreshBackoff) && now.Before(token.Expiry) {
+			m.mu.Unlock()
+			return token, nil
+		}
+		call := m.refreshing
+		if call == nil {
+			break
+		}
+		m.mu.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if !call.scoped && scopes == nil {
+			return call.token, call.err
+		}
+		if call.err == nil && !call.scoped {
+			token = call.token
+		} else if stored, err := m.tokenStore().Load(ctx); err == nil && stored.AccessToken == token.AccessToken {
+			// A down-scoped refresh only persists the rotated refresh token
+			token = stored
+		}
+		m.mu.Lock()
+	}
+	call := &refreshCall{done: make(chan struct{}), scoped: scopes != nil}
+	m.refreshing = call
+	m.mu.Unlock()
+
+	if scopes == nil {
+		call.token, call.err = m.doRefresh(ctx, token)
+	} else {
+		call.token, call.err = m.doScopedRefresh(ctx, token, scopes)
+	}
+
+	m.mu.Lock()
+	m.refreshing = nil
+	m.mu.Unlock()
+	close(call.done)
+	return call.token, call.err
+}
+
+func (m *Manager) doRefresh(ctx context.Context, token *oauth2.Token) (*oauth2.Token, error) {
+	logger := m.logger(ctx)
+
+	// Another goroutine or process may have refreshed the token since it
+	// was loaded; its refresh token may have been rotated already.
+	if stored, err := m.tokenStore().Load(ctx); err == nil && m.TokenUsable(stored) && stored.AccessToken != token.AccessToken {
+		return stored, nil
+	}
+
+	logger.Debug("Refreshing token")
+	ts := m.oauth2ConfigOAuth2().TokenSource(m.oauth2Context(ctx), &oauth2.Token{RefreshToken: token.RefreshToken})
+	refreshed, err := m.withRetry(ctx, "Token refresh", ts.Token)
+	if err != nil {
+		m.record(EventTokenRefreshed, "Failed to refresh token", err)
+		return nil, fmt.Errorf("validate/refresh token: %w", refreshError(err))
+	}
+
+	// A misconfigured provider may return a token expiring no later than
+	// the previous one, which would be refreshed again right away.
+	stale := !refreshed.Expiry.IsZero() && !token.Expiry.IsZero() && !refreshed.Expiry.After(token.Expiry)
+	if stale {
+		logger.Warn("Refreshed token expires no later than the previous one", slog.Time("expiry", refreshed.Expiry))
+		m.mu.Lock()
+		m.refreshBackoff = m.now().Add(staleRefreshBackoff)
+		m.mu.Unlock()
+	}
+
+	// Providers not rotating refresh tokens may omit it from the response;
+	// the current one stays valid and must be kept.
+	if refreshed.RefreshToken == "" {
+		logger.Debug("Refresh response carries no refresh token, keeping the current one")
+		refreshed.RefreshToken = token.RefreshToken
+	}
+
+	// The scope is usually omitted from refresh responses, meaning it is
+	// unchanged (RFC 6749, section 5.1). The ID token and the client ID
+	// recorded by the Manager are carried over likewise.
+	refreshed = m.withExtraKeys(refreshed, token)
+	if kept := keptExtra(refreshed, token); len(kept) > 0 {
+		refreshed = withExtra(refreshed, kept)
+	}
+
+	logger.Debug("Token refreshed, saving to store")
+	m.record(EventTokenRefreshed, "Token refreshed", nil)
+	if err := m.tokenStore().Save(ctx, refreshed); err != nil {
+		// Log warning but don't fail the request
+		logger.Warn("Failed to save refreshed token", slog.Any("error", err))
+	}
+	// The token is saved all the same: the provider may have rotated the
+	// refresh token, invalidating the previous one.
+	if stale && m.Config.RejectStaleRefresh {
+		return nil, ErrStaleRefresh
+	}
+	return refreshed, nil
+}
+
+// refreshError converts an error of a refresh request, reporting a refresh
+// token rejected as invalid_grant as ErrRefreshTokenExpired.
+func refreshError(err error) error {
+	err = asTokenError(err)
+	var te *TokenError
+	if errors.As(err, &te) && te.Code == "invalid_grant" {
+		return fmt.Errorf("%w: %w", ErrRefreshTokenExpired, err)
+	}
+	return err
+}
+
+// RefreshWithScopes refreshes the stored token requesting only scopes,
+// which must be a subset of the scopes granted, to obtain a down-scoped
+// access token for a specific operation (RFC 6749, section 6).
+//
+// The down-scoped token is returned only: the stored token, handed out by
+// GetToken and NewOAuth2Client, keeps its access token and the scopes of
+// the grant. A refresh token rotated by the provider is persisted.
+func (m *Manager) RefreshWithScopes(ctx context.Context, scopes ...string) (*oauth2.Token, error) {
+	token, err := m.tokenStore().Load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load token: %w", err)
+	}
+	if token.RefreshToken == "" {
+		return nil, errors.New("stored token has no refresh token")
+	}
+	return m.sharedRefresh(ctx, token, scopes)
+}
+
+// doScopedRefresh performs the refresh of RefreshWithScopes.
+func (m *Manager) doScopedRefresh(ctx context.Context, token *oauth2.Token, scopes []string) (*oauth2.Token, error) {
+	refreshed, err := m.withRetry(ctx, "Token refresh", func() (*oauth2.Token, error) {
+		return m.retrieveToken(m.oauth2Context(ctx), url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {token.RefreshToken},
+			"scope":         {strings.Join(scopes, " ")},
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("refresh token: %w", refreshError(err))
+	}
+	m.record(EventTokenRefreshed, "Token refreshed with scopes "+strings.Join(scopes, " "), nil)
+
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = token.RefreshToken
+	} else if refreshed.RefreshToken != token.RefreshToken {
+		// The previous refresh token has been invalidated
+		rotated := *token
+		rotated.RefreshToken = refreshed.RefreshToken
+		if err := m.saveToken(ctx, &rotated); err != nil {
+			return nil, err
+		}
+	}
+
+	refreshed = m.withExtraKeys(refreshed, token)
+	kept := keptExtra(refreshed, token)
+	// Omitted scopes mean those requested were granted
+	if refreshed.Extra("scope") == nil {
+		kept["scope"] = strings.Join(scopes, " ")
+	}
+	return withExtra(refreshed, kept), nil
+}
+
+// keptExtra returns the preserved extra fields of token missing from
+// refreshed, which replaces it.
+func keptExtra(refreshed, token *oauth2.Token) map[string]any {
+	kept := make(map[string]any)
+	for _, key := range extraKeys {
+		if refreshed.Extra(key) == nil && token.Extra(key) != nil {
+			kept[key] = token.Extra(key)
+		}
+	}
+	return kept
+}
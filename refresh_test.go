@@ -0,0 +1,377 @@
+package oauth2kit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// storeToken saves token as the stored token of m.
+func storeToken(t *testing.T, m *Manager, token *oauth2.Token) {
+	t.Helper()
+	if err := m.tokenStore().Save(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expiredToken returns a token which must be refreshed, granted scopes.
+func expiredToken(scopes string) *oauth2.Token {
+	return (&oauth2.Token{
+		AccessToken:  "expired",
+		TokenType:    "Bearer",
+		RefreshToken: "refresh-0",
+		Expiry:       time.Now().Add(-time.Minute),
+	}).WithExtra(map[string]any{"scope": scopes})
+}
+
+func TestRefreshWithScopesKeepsStoredToken(t *testing.T) {
+	p := newFakeProvider(t)
+	p.rotate = true
+	m := p.manager(t)
+	ctx := context.Background()
+	stored := expiredToken("read write")
+	stored.Expiry = time.Now().Add(time.Hour)
+	storeToken(t, m, stored)
+
+	narrow, err := m.RefreshWithScopes(ctx, "read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.form(0).Get("scope"); got != "read" {
+		t.Errorf("requested scope %q, want %q", got, "read")
+	}
+	if narrow.Extra("scope") != "read" {
+		t.Errorf("down-scoped token has scope %v, want %q", narrow.Extra("scope"), "read")
+	}
+
+	token, err := m.tokenStore().Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "expired" || token.Extra("scope") != "read write" {
+		t.Errorf("stored token replaced: access token %q, scope %v", token.AccessToken, token.Extra("scope"))
+	}
+	if token.RefreshToken != narrow.RefreshToken {
+		t.Errorf("stored refresh token %q, want the rotated %q", token.RefreshToken, narrow.RefreshToken)
+	}
+	if _, err := m.GetTokenWithScopes(ctx, "read", "write"); err != nil {
+		t.Errorf("GetTokenWithScopes after a down-scoped refresh: %v", err)
+	}
+}
+
+func TestRefreshWithScopesSharesRotatedRefreshToken(t *testing.T) {
+	p := newFakeProvider(t)
+	p.rotate = true
+	m := p.manager(t)
+	ctx := context.Background()
+	storeToken(t, m, expiredToken("read write"))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	wg.Go(func() {
+		_, err := m.RefreshWithScopes(ctx, "read")
+		errs <- err
+	})
+	wg.Go(func() {
+		_, err := m.Authenticate(ctx)
+		errs <- err
+	})
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if n := p.calls(); n != 2 {
+		t.Errorf("%d token requests, want 2", n)
+	}
+}
+
+func TestConcurrentRefreshesShareOneRequest(t *testing.T) {
+	p := newFakeProvider(t)
+	p.respond = func(n int, form url.Values) (int, map[string]any) {
+		time.Sleep(50 * time.Millisecond) // keep the refresh in flight
+		return http.StatusOK, map[string]any{
+			"access_token": "refreshed", "token_type": "Bearer", "expires_in": 3600,
+		}
+	}
+	m := p.manager(t)
+	ctx := context.Background()
+	expired := expiredToken("read")
+	storeToken(t, m, expired)
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Go(func() {
+			token, err := m.refresh(ctx, expired)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if token.AccessToken != "refreshed" {
+				t.Errorf("got access token %q, want %q", token.AccessToken, "refreshed")
+			}
+		})
+	}
+	wg.Wait()
+	if n := p.calls(); n != 1 {
+		t.Errorf("%d token requests, want 1", n)
+	}
+}
+
+func TestExpiryWarningDefault(t *testing.T) {
+	p := newFakeProvider(t)
+	m := p.manager(t)
+	var warnings []time.Duration
+	m.Config.OnExpiryWarning = func(remaining time.Duration) {
+		warnings = append(warnings, remaining)
+	}
+	s := &persistingTokenSource{ctx: context.Background(), m: m, token: &oauth2.Token{
+		AccessToken: "access",
+		Expiry:      time.Now().Add(2 * time.Minute),
+	}}
+	for range 2 {
+		if _, err := s.Token(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(warnings) != 1 || warnings[0] > 2*time.Minute {
+		t.Errorf("warnings %v, want one within the default window", warnings)
+	}
+}
+
+func TestReauthOnInvalidGrantKeepsScopes(t *testing.T) {
+	p := newFakeProvider(t)
+	p.respond = func(n int, form url.Values) (int, map[string]any) {
+		if form.Get("grant_type") == "refresh_token" {
+			return http.StatusBadRequest, map[string]any{"error": "invalid_grant"}
+		}
+		return http.StatusOK, map[string]any{"access_token": "access", "token_type": "Bearer", "expires_in": 3600}
+	}
+	m := p.manager(t)
+	signIn(t, m, "code")
+	requested := requestedScopes(m)
+	m.Config.ReauthOnInvalidGrant = true
+	storeToken(t, m, expiredToken("write"))
+
+	res, err := m.Authenticate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Interactive {
+		t.Error("no new authorization")
+	}
+	if !slices.Equal(*requested, []string{"read write"}) {
+		t.Errorf("requested scopes %q, want %q", *requested, "read write")
+	}
+}
+
+// fakeClock is a Config.Clock which only moves when advanced.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Now()}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestReuseTokenSourceFollowsClock(t *testing.T) {
+	p := newFakeProvider(t)
+	m := p.manager(t)
+	clock := newFakeClock()
+	m.Config.Clock = clock.Now
+	stored := expiredToken("read")
+	stored.Expiry = clock.Now().Add(time.Hour)
+	storeToken(t, m, stored)
+
+	ts, err := m.ReuseTokenSource(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := ts.Token(); err != nil || token.AccessToken != "expired" {
+		t.Fatalf("Token() = %v, %v, want the stored token", token, err)
+	}
+	clock.Advance(2 * time.Hour)
+	token, err := ts.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access-1" {
+		t.Errorf("access token %q after the token expired by Clock, want a refreshed one", token.AccessToken)
+	}
+}
+
+func TestRefreshWithoutRefreshTokenKeepsStoredOne(t *testing.T) {
+	p := newFakeProvider(t)
+	p.respond = func(n int, form url.Values) (int, map[string]any) {
+		return http.StatusOK, map[string]any{"access_token": "refreshed", "token_type": "Bearer", "expires_in": 3600}
+	}
+	m := p.manager(t)
+	ctx := context.Background()
+	storeToken(t, m, expiredToken("read"))
+
+	res, err := m.Authenticate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Refreshed || res.Token.RefreshToken != "refresh-0" {
+		t.Errorf("refreshed %v with refresh token %q, want the stored one", res.Refreshed, res.Token.RefreshToken)
+	}
+	stored, err := m.tokenStore().Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.AccessToken != "refreshed" || stored.RefreshToken != "refresh-0" {
+		t.Errorf("stored access token %q and refresh token %q, want %q and %q",
+			stored.AccessToken, stored.RefreshToken, "refreshed", "refresh-0")
+	}
+}
+
+func TestRefreshClientAuthentication(t *testing.T) {
+	for name, tc := range map[string]struct {
+		public    bool
+		authStyle oauth2.AuthStyle
+		secret    string // expected in the form
+		basic     bool   // credentials expected in the Authorization header
+	}{
+		"confidential in params": {authStyle: oauth2.AuthStyleInParams, secret: "secret"},
+		"confidential in header": {authStyle: oauth2.AuthStyleInHeader, basic: true},
+		"public":                 {public: true, authStyle: oauth2.AuthStyleInHeader},
+	} {
+		for _, scoped := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/scoped=%v", name, scoped), func(t *testing.T) {
+				p := newFakeProvider(t)
+				m := p.manager(t)
+				m.Config.PublicClient = tc.public
+				m.Config.AuthStyle = tc.authStyle
+				ctx := context.Background()
+				storeToken(t, m, expiredToken("read"))
+
+				var err error
+				if scoped {
+					_, err = m.RefreshWithScopes(ctx, "read")
+				} else {
+					_, err = m.refresh(ctx, expiredToken("read"))
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				form := p.form(0)
+				if got := form.Get("client_secret"); got != tc.secret {
+					t.Errorf("client_secret %q, want %q", got, tc.secret)
+				}
+				if got := form.Get("client_id"); (got == "") != tc.basic {
+					t.Errorf("client_id %q in the form with basic authentication %v", got, tc.basic)
+				}
+				id, secret, ok := p.requests[0].BasicAuth()
+				if ok != tc.basic || (ok && (id != "client" || secret != "secret")) {
+					t.Errorf("basic authentication %q:%q (%v), want it %v", id, secret, ok, tc.basic)
+				}
+			})
+		}
+	}
+}
+
+func TestStaleRefreshBacksOff(t *testing.T) {
+	p := newFakeProvider(t)
+	p.respond = func(n int, form url.Values) (int, map[string]any) {
+		return http.StatusOK, map[string]any{"access_token": fmt.Sprintf("stale-%d", n), "token_type": "Bearer", "expires_in": 5}
+	}
+	m := p.manager(t)
+	clock := newFakeClock()
+	m.Config.Clock = clock.Now
+	ctx := context.Background()
+	token := expiredToken("read")
+	token.Expiry = clock.Now().Add(8 * time.Second) // within ExpiryDelta
+	storeToken(t, m, token)
+
+	refreshed, err := m.refresh(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.AccessToken != "stale-1" {
+		t.Fatalf("access token %q, want %q", refreshed.AccessToken, "stale-1")
+	}
+	for range 3 {
+		if again, err := m.refresh(ctx, refreshed); err != nil || again.AccessToken != "stale-1" {
+			t.Fatalf("refresh during the backoff = %v, %v, want the current token", again, err)
+		}
+	}
+	if n := p.calls(); n != 1 {
+		t.Errorf("%d token requests during the backoff, want 1", n)
+	}
+
+	// The backoff ends once the token has actually expired
+	clock.Advance(staleRefreshBackoff + time.Second)
+	if _, err := m.refresh(ctx, refreshed); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.calls(); n != 2 {
+		t.Errorf("%d token requests after the backoff, want 2", n)
+	}
+}
+
+func TestRejectStaleRefresh(t *testing.T) {
+	p := newFakeProvider(t)
+	p.respond = func(n int, form url.Values) (int, map[string]any) {
+		return http.StatusOK, map[string]any{"access_token": "stale", "token_type": "Bearer", "refresh_token": "rotated", "expires_in": 5}
+	}
+	m := p.manager(t)
+	m.Config.RejectStaleRefresh = true
+	token := expiredToken("read")
+	token.Expiry = time.Now().Add(8 * time.Second)
+	if _, err := m.refresh(context.Background(), token); !errors.Is(err, ErrStaleRefresh) {
+		t.Errorf("got %v, want ErrStaleRefresh", err)
+	}
+	stored, err := m.tokenStore().Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.RefreshToken != "rotated" {
+		t.Errorf("stored refresh token %q, want the rotated one", stored.RefreshToken)
+	}
+}
+
+func TestSecretRotationKeepsRefreshToken(t *testing.T) {
+	p := newFakeProvider(t)
+	m := p.manager(t)
+	m.Config.ClientSecret = "rotated"
+	m.Config.StrictClientMatch = true
+	token := expiredToken("read")
+	storeToken(t, m, withExtra(token, map[string]any{"client_id": "client"}))
+
+	res, err := m.Authenticate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Refreshed || res.Interactive {
+		t.Errorf("result %+v, want the stored token refreshed", res)
+	}
+	form := p.form(0)
+	if form.Get("refresh_token") != "refresh-0" || form.Get("client_secret") != "rotated" {
+		t.Errorf("refreshed %q with secret %q, want the stored refresh token with the rotated secret",
+			form.Get("refresh_token"), form.Get("client_secret"))
+	}
+}
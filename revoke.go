@@ -0,0 +1,32 @@
+package oauth2kit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/oauth2"
+)
+
+// revoke revokes token at Config.RevocationURL (RFC 7009). The refresh
+// token is revoked if there is one, which also invalidates the access
+// tokens issued with it at most providers.
+func (m *Manager) revoke(ctx context.Context, token *oauth2.Token) error {
+	if m.Config.RevocationURL == "" {
+		return errors.New("no revocation endpoint configured")
+	}
+	params := url.Values{"token": {token.AccessToken}, "token_type_hint": {"access_token"}}
+	if token.RefreshToken != "" {
+		params = url.Values{"token": {token.RefreshToken}, "token_type_hint": {"refresh_token"}}
+	}
+	resp, body, err := m.postClientForm(ctx, m.Config.RevocationURL, params)
+	if err != nil {
+		return fmt.Errorf("revoke token: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("revoke token: endpoint returned %s: %s", resp.Status, body)
+	}
+	return nil
+}
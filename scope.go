@@ -0,0 +1,81 @@
+package oauth2kit
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// grantedScopes returns the scopes recorded on token. Tokens stored without
+// scope information are assumed to carry Config.Scopes.
+func (m *Manager) grantedScopes(token *oauth2.Token) []string {
+	if scope, _ := token.Extra("scope").(string); scope != "" {
+		return strings.Fields(scope)
+	}
+	return m.Config.Scopes
+}
+
+// reauthScopes returns the scopes to request when re-authorizing in place
+// of token: Config.Scopes and those granted to token, so that the new token
+// neither misses a configured scope nor downgrades the previous one.
+func (m *Manager) reauthScopes(token *oauth2.Token) []string {
+	return unionScopes(m.Config.Scopes, m.grantedScopes(token))
+}
+
+// missingScopes returns the scopes of requested that are not in granted.
+func missingScopes(granted, requested []string) []string {
+	var missing []string
+	for _, s := range requested {
+		if !slices.Contains(granted, s) {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
+
+// unionScopes returns the scopes of a followed by those of b missing from a.
+func unionScopes(a, b []string) []string {
+	union := slices.Clone(a)
+	for _, s := range b {
+		if !slices.Contains(union, s) {
+			union = append(union, s)
+		}
+	}
+	return union
+}
+
+// withRequestedScopes records scopes on token when the provider omitted the
+// "scope" field, which per RFC 6749 (section 5.1) means the requested scopes
+// were granted.
+func withRequestedScopes(token *oauth2.Token, scopes []string) *oauth2.Token {
+	if scope, _ := token.Extra("scope").(string); scope != "" || len(scopes) == 0 {
+		return token
+	}
+	return withExtra(token, map[string]any{"scope": strings.Join(scopes, " ")})
+}
+
+// checkScopes records the granted scopes on a newly issued token, then
+// warns through the logger and Config.OnScopeDowngrade if the provider
+// granted fewer scopes than requested, e.g. after partial consent. The
+// token is returned either way.
+func (m *Manager) checkScopes(ctx context.Context, token *oauth2.Token, requested []string) *oauth2.Token {
+	token = withRequestedScopes(token, requested)
+	granted := m.grantedScopes(token)
+	if missing := missingScopes(granted, requested); len(missing) > 0 {
+		m.logger(ctx).Warn("Scopes not granted by the provider", slog.Any("missing", missing))
+		if m.Config.OnScopeDowngrade != nil {
+			m.Config.OnScopeDowngrade(requested, granted)
+		}
+	}
+	return token
+}
+
+// ScopesEqual reports whether a and b contain the same scopes, ignoring
+// order and duplicates, so that reordering Config.Scopes does not trigger
+// a new authorization.
+func ScopesEqual(a, b []string) bool {
+	return len(missingScopes(a, b)) == 0 && len(missingScopes(b, a)) == 0
+}
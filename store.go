@@ -0,0 +1,723 @@
+package oauth2kit
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	defaultTokenFile = "token.json"
+
+	// defaultMaxTokenFileSize bounds the size of the token files read, so
+	// that a file replaced by garbage is not decoded.
+	defaultMaxTokenFileSize = 1 << 20
+)
+
+// ErrTokenNotFound is returned by a TokenStore when no token has been
+// stored yet. The Manager starts a new authorization flow in that case.
+var ErrTokenNotFound = errors.New("token not found")
+
+// TokenStore persists tokens between runs.
+type TokenStore interface {
+	// Load returns the stored token, or ErrTokenNotFound if there is none.
+	Load(ctx context.Context) (*oauth2.Token, error)
+
+	// Save persists the token, replacing any previously stored token.
+	Save(ctx context.Context, token *oauth2.Token) error
+
+	// Delete removes the stored token. Deleting a missing token is not
+	// an error.
+	Delete(ctx context.Context) error
+}
+
+// KeyedTokenStore is a TokenStore holding the tokens of several accounts
+// or configurations, such as NamespacedFileTokenStore. Prune uses it to
+// visit every token.
+type KeyedTokenStore interface {
+	TokenStore
+
+	// Keys returns the keys of the stored tokens.
+	Keys(ctx context.Context) ([]string, error)
+
+	// ForKey returns the store of the token with key.
+	ForKey(key string) TokenStore
+}
+
+func (m *Manager) tokenStore() TokenStore {
+	if m.Config.NoPersistence {
+		return noTokenStore{}
+	}
+	if m.Config.TokenStore != nil {
+		return m.Config.TokenStore
+	}
+	path := m.Config.TokenFile
+	if path == "" {
+		path = defaultTokenFile
+	}
+	if m.Config.NamespaceTokens {
+		return &NamespacedFileTokenStore{Path: path, Key: m.TokenKey(), MaxSize: m.Config.MaxTokenFileSize}
+	}
+	return &FileTokenStore{Path: path, MaxSize: m.Config.MaxTokenFileSize, Format: m.Config.TokenFormat, Clock: m.now}
+}
+
+// noTokenStore is the TokenStore used with Config.NoPersistence. It never
+// holds a token.
+type noTokenStore struct{}
+
+func (noTokenStore) Load(context.Context) (*oauth2.Token, error) { return nil, ErrTokenNotFound }
+func (noTokenStore) Save(context.Context, *oauth2.Token) error   { return nil }
+func (noTokenStore) Delete(context.Context) error                { return nil }
+
+// Prune removes the stored tokens which cannot be used anymore: expired
+// tokens without refresh token and, with Config.IntrospectionURL, tokens
+// reported inactive. With a KeyedTokenStore every token of the store is
+// visited, otherwise only the Manager's token is. It returns the keys of
+// the removed tokens; the Manager's own token is reported as "".
+//
+// The tokens of other keys may belong to other applications or providers:
+// they are never sent to Config.IntrospectionURL, and are only removed
+// once expired without refresh token.
+func (m *Manager) Prune(ctx context.Context) (removed []string, err error) {
+	stores := map[string]TokenStore{}
+	store := m.tokenStore()
+	own := ""
+	if keyed, ok := store.(KeyedTokenStore); ok {
+		own = m.TokenKey()
+		if ns, ok := store.(*NamespacedFileTokenStore); ok {
+			own = ns.Key
+		}
+		keys, err := keyed.Keys(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list tokens: %w", err)
+		}
+		for _, key := range keys {
+			stores[key] = keyed.ForKey(key)
+		}
+	} else {
+		stores[""] = store
+	}
+
+	logger := m.logger(ctx)
+	var errs []error
+	for _, key := range slices.Sorted(maps.Keys(stores)) {
+		s := stores[key]
+		token, err := s.Load(ctx)
+		if errors.Is(err, ErrTokenNotFound) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("load token %q: %w", key, err))
+			continue
+		}
+		stale := !m.TokenUsable(token) && token.RefreshToken == ""
+		if !stale && key == own && m.Config.IntrospectionURL != "" && m.TokenUsable(token) {
+			active, err := m.introspect(ctx, token)
+			stale = err == nil && !active
+		}
+		if !stale {
+			continue
+		}
+		if err := s.Delete(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("delete token %q: %w", key, err))
+			continue
+		}
+		logger.Debug("Pruned token", slog.String("key", key))
+		removed = append(removed, key)
+	}
+	return removed, errors.Join(errs...)
+}
+
+// MigrateStore copies the token stored in from, with its metadata, to to,
+// e.g. when moving from a token file in the working directory to one under
+// os.UserConfigDir, or from a file to a keyring. Users keep their session
+// instead of having to authenticate again.
+//
+// Nothing is copied if from holds no token or to already holds one. With
+// deleteSource, the token is deleted from from once it has been saved to
+// to; it is left in place otherwise, including when nothing was copied.
+func (m *Manager) MigrateStore(ctx context.Context, from, to TokenStore, deleteSource bool) error {
+	logger := m.logger(ctx)
+
+	if _, err := to.Load(ctx); err == nil {
+		logger.Debug("Destination store already holds a token, skipping migration")
+		return nil
+	} else if !errors.Is(err, ErrTokenNotFound) {
+		return fmt.Errorf("load destination token: %w", err)
+	}
+
+	token, err := from.Load(ctx)
+	if errors.Is(err, ErrTokenNotFound) {
+		logger.Debug("Source store holds no token, nothing to migrate")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("load source token: %w", err)
+	}
+	if err := to.Save(ctx, token); err != nil {
+		return fmt.Errorf("store token: %w", err)
+	}
+	logger.Debug("✓ Token migrated")
+	if deleteSource {
+		if err := from.Delete(ctx); err != nil {
+			return fmt.Errorf("delete source token: %w", err)
+		}
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// File
+// ----------------------------------------------------------------------------
+
+// FileTokenStore persists the token as JSON in a file.
+type FileTokenStore struct {
+	// Path is the path of the token file.
+	Path string
+
+	// Format is the layout of the file, TokenFormatNative or
+	// TokenFormatPortable. Both are read regardless of it.
+	// Default: TokenFormatNative
+	Format TokenFormat
+
+	// MaxSize is the size above which the file is rejected instead of
+	// being decoded.
+	// Default: 1MB
+	MaxSize int64
+
+	// Clock returns the current time the "expires_in" of portable files
+	// is counted from, e.g. Config.Clock. If nil, time.Now is used.
+	Clock func() time.Time
+}
+
+func (s *FileTokenStore) Load(ctx context.Context) (*oauth2.Token, error) {
+	token, err := load(s.Path, s.MaxSize)
+	if os.IsNotExist(err) {
+		return nil, ErrTokenNotFound
+	}
+	return token, err
+}
+
+func (s *FileTokenStore) Save(ctx context.Context, token *oauth2.Token) error {
+	if s.Format == TokenFormatPortable {
+		return storePortable(s.Path, token, s.now())
+	}
+	return store(s.Path, token)
+}
+
+func (s *FileTokenStore) now() time.Time {
+	if s.Clock != nil {
+		return s.Clock()
+	}
+	return time.Now()
+}
+
+func (s *FileTokenStore) Delete(ctx context.Context) error {
+	if err := os.Remove(s.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// Namespaced file
+// ----------------------------------------------------------------------------
+
+// NamespacedFileTokenStore persists tokens in a JSON file holding one token
+// per key, so that several applications or configurations can share the
+// same file without overwriting each other's token. Use Manager.TokenKey
+// as the key, or set Config.NamespaceTokens.
+//
+// The file is rewritten on each save; concurrent writers from different
+// processes may lose updates.
+type NamespacedFileTokenStore struct {
+	// Path is the path of the token file.
+	Path string
+
+	// Key identifies the token within the file.
+	Key string
+
+	// MaxSize is the size above which the file is rejected instead of
+	// being decoded.
+	// Default: 1MB
+	MaxSize int64
+}
+
+func (s *NamespacedFileTokenStore) Load(ctx context.Context) (*oauth2.Token, error) {
+	records, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	rec, ok := records[s.Key]
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+	return rec.token(), nil
+}
+
+func (s *NamespacedFileTokenStore) Save(ctx context.Context, token *oauth2.Token) error {
+	records, err := s.read()
+	if err != nil {
+		return err
+	}
+	records[s.Key] = newTokenRecord(token)
+	return s.write(records)
+}
+
+func (s *NamespacedFileTokenStore) Delete(ctx context.Context) error {
+	records, err := s.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := records[s.Key]; !ok {
+		return nil
+	}
+	delete(records, s.Key)
+	return s.write(records)
+}
+
+// Keys returns the keys of the tokens held by the file.
+func (s *NamespacedFileTokenStore) Keys(ctx context.Context) ([]string, error) {
+	records, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	return slices.Sorted(maps.Keys(records)), nil
+}
+
+// ForKey returns the store of the token with key in the same file.
+func (s *NamespacedFileTokenStore) ForKey(key string) TokenStore {
+	return &NamespacedFileTokenStore{Path: s.Path, Key: key, MaxSize: s.MaxSize}
+}
+
+func (s *NamespacedFileTokenStore) read() (map[string]tokenRecord, error) {
+	records := make(map[string]tokenRecord)
+	b, err := readFile(s.Path, s.MaxSize)
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &records); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", s.Path, err)
+	}
+	return records, nil
+}
+
+func (s *NamespacedFileTokenStore) write(records map[string]tokenRecord) error {
+	b, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.Path, b, 0600)
+}
+
+// TokenKey returns a stable key identifying the tokens of this
+// configuration, derived from the token endpoint, the client ID and the
+// sorted scopes. It is the key used with Config.NamespaceTokens.
+func (m *Manager) TokenKey() string {
+	scopes := slices.Clone(m.Config.Scopes)
+	slices.Sort(scopes)
+	scopes = slices.Compact(scopes)
+	h := sha256.New()
+	for _, s := range []string{m.Config.Endpoint.TokenURL, m.Config.ClientID, strings.Join(scopes, " ")} {
+		io.WriteString(h, s)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// ----------------------------------------------------------------------------
+// Environment
+// ----------------------------------------------------------------------------
+
+// EnvTokenStore reads the token from an environment variable holding the
+// base64-encoded token JSON. It suits containers with read-only filesystems
+// where the token is injected by the operator.
+//
+// Refreshed tokens cannot be written back to the environment. They are
+// written to Path if set, and otherwise logged so that the operator can
+// re-inject them.
+type EnvTokenStore struct {
+	// Variable is the name of the environment variable holding the token.
+	Variable string
+
+	// Path is an optional writable file where refreshed tokens are saved.
+	// When the file exists, it takes precedence over the environment.
+	Path string
+
+	// Logger receives the refreshed token when Path is empty.
+	// If nil, slog.Default() is used.
+	Logger *slog.Logger
+}
+
+func (s *EnvTokenStore) Load(ctx context.Context) (*oauth2.Token, error) {
+	if s.Path != "" {
+		token, err := load(s.Path, 0)
+		if err == nil || !os.IsNotExist(err) {
+			return token, err
+		}
+	}
+	value := os.Getenv(s.Variable)
+	if value == "" {
+		return nil, ErrTokenNotFound
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("decode $%s: %w", s.Variable, err)
+	}
+	token, err := decodeToken(bytes.NewReader(b), time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("decode $%s: %w", s.Variable, err)
+	}
+	return token, nil
+}
+
+func (s *EnvTokenStore) Save(ctx context.Context, token *oauth2.Token) error {
+	if s.Path != "" {
+		return store(s.Path, token)
+	}
+	var buf bytes.Buffer
+	if err := encodeToken(&buf, token); err != nil {
+		return err
+	}
+	logger := s.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.Warn("Token updated; re-inject it via the environment variable", slog.String("variable", s.Variable),
+		slog.String("token", base64.StdEncoding.EncodeToString(buf.Bytes())))
+	return nil
+}
+
+func (s *EnvTokenStore) Delete(ctx context.Context) error {
+	if s.Path != "" {
+		if err := os.Remove(s.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Unsetenv(s.Variable)
+}
+
+// ----------------------------------------------------------------------------
+// Multiple stores
+// ----------------------------------------------------------------------------
+
+// MultiTokenStore persists the token to several stores, e.g. a local file
+// and a remote store for redundancy, or both sides of a migration.
+//
+// Load returns the token of the first store holding one; stores failing
+// are skipped. Save and Delete apply to every store, even when some fail.
+type MultiTokenStore struct {
+	// Stores are the stores, in the order Load tries them.
+	Stores []TokenStore
+
+	// BestEffort makes Save succeed as long as one store was written, the
+	// failures of the others being logged. By default Save fails if any
+	// store fails, with the errors joined.
+	BestEffort bool
+
+	// Logger receives the failures ignored with BestEffort or by Load.
+	// If nil, slog.Default() is used.
+	Logger *slog.Logger
+}
+
+func (s *MultiTokenStore) Load(ctx context.Context) (*oauth2.Token, error) {
+	var errs []error
+	for i, st := range s.Stores {
+		token, err := st.Load(ctx)
+		if err == nil {
+			for _, err := range errs {
+				s.logger().Warn("Token store failed, falling back", slog.Any("error", err))
+			}
+			return token, nil
+		}
+		if !errors.Is(err, ErrTokenNotFound) {
+			errs = append(errs, fmt.Errorf("store %d: %w", i, err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil, ErrTokenNotFound
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (s *MultiTokenStore) Save(ctx context.Context, token *oauth2.Token) error {
+	var errs []error
+	for i, st := range s.Stores {
+		if err := st.Save(ctx, token); err != nil {
+			errs = append(errs, fmt.Errorf("store %d: %w", i, err))
+		}
+	}
+	if s.BestEffort && len(errs) < len(s.Stores) {
+		for _, err := range errs {
+			s.logger().Warn("Failed to save token", slog.Any("error", err))
+		}
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+func (s *MultiTokenStore) Delete(ctx context.Context) error {
+	var errs []error
+	for i, st := range s.Stores {
+		if err := st.Delete(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("store %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *MultiTokenStore) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
+
+// ----------------------------------------------------------------------------
+// Helper functions
+// ----------------------------------------------------------------------------
+
+func store(fileName string, token *oauth2.Token) error {
+	f, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return encodeToken(f, token)
+}
+
+func load(fileName string, maxSize int64) (*oauth2.Token, error) {
+	b, err := readFile(fileName, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return decodeToken(bytes.NewReader(b), fi.ModTime())
+}
+
+// readFile reads the file at fileName, failing if it is larger than
+// maxSize, or defaultMaxTokenFileSize if maxSize is zero. Errors opening
+// the file are returned as is, for os.IsNotExist.
+func readFile(fileName string, maxSize int64) ([]byte, error) {
+	maxSize = cmp.Or(maxSize, defaultMaxTokenFileSize)
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(io.LimitReader(f, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > maxSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes, refusing to decode it", fileName, maxSize)
+	}
+	return b, nil
+}
+
+// tokenRecord is the persisted form of a token. oauth2.Token drops the extra
+// fields of the token response when encoded, so the ones the Manager relies
+// on are stored alongside it.
+type tokenRecord struct {
+	*oauth2.Token
+
+	// Scope is the space-delimited list of granted scopes.
+	Scope string `json:"scope,omitempty"`
+
+	// IDToken is the OpenID Connect ID token, if any.
+	IDToken string `json:"id_token,omitempty"`
+
+	// ClientID is the client the token was issued to.
+	ClientID string `json:"client_id,omitempty"`
+
+	// GrantedAt is when the authorization that issued the token completed,
+	// in RFC 3339 format. Refreshes keep it.
+	GrantedAt string `json:"granted_at,omitempty"`
+
+	// Extra holds the response fields listed in Config.ExtraKeys.
+	Extra map[string]any `json:"extra,omitempty"`
+}
+
+func newTokenRecord(token *oauth2.Token) tokenRecord {
+	rec := tokenRecord{Token: token}
+	rec.Scope, _ = token.Extra("scope").(string)
+	rec.IDToken, _ = token.Extra("id_token").(string)
+	rec.ClientID, _ = token.Extra("client_id").(string)
+	rec.GrantedAt, _ = token.Extra("granted_at").(string)
+	rec.Extra, _ = token.Extra("extra").(map[string]any)
+	return rec
+}
+
+func (rec tokenRecord) token() *oauth2.Token {
+	token := rec.Token
+	if token == nil {
+		token = &oauth2.Token{}
+	}
+	extra := make(map[string]any)
+	if rec.Scope != "" {
+		extra["scope"] = rec.Scope
+	}
+	if rec.IDToken != "" {
+		extra["id_token"] = rec.IDToken
+	}
+	if rec.ClientID != "" {
+		extra["client_id"] = rec.ClientID
+	}
+	if rec.GrantedAt != "" {
+		extra["granted_at"] = rec.GrantedAt
+	}
+	if len(rec.Extra) > 0 {
+		extra["extra"] = rec.Extra
+	}
+	if len(extra) > 0 {
+		token = withExtra(token, extra)
+	}
+	return token
+}
+
+func encodeToken(w io.Writer, token *oauth2.Token) error {
+	return json.NewEncoder(w).Encode(newTokenRecord(token))
+}
+
+// TokenFormat is the layout of a token file.
+type TokenFormat string
+
+const (
+	// TokenFormatNative is the JSON encoding of oauth2.Token, with the
+	// extra fields the Manager relies on.
+	TokenFormatNative TokenFormat = ""
+
+	// TokenFormatPortable is the token response shape of RFC 6749
+	// (section 5.1), readable by tools in other languages: "expires_in"
+	// holds the seconds remaining when the file was written, and "expiry"
+	// the expiry time in RFC 3339 format with a second precision. Files
+	// written by other tools without "expiry" expire "expires_in" seconds
+	// after their modification time.
+	TokenFormatPortable TokenFormat = "portable"
+)
+
+// portableToken is the persisted form of a token in TokenFormatPortable.
+// Its fields are a subset of tokenRecord's, so decodeToken reads it.
+type portableToken struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+	Expiry       string `json:"expiry,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	ClientID     string `json:"client_id,omitempty"`
+	GrantedAt    string `json:"granted_at,omitempty"`
+
+	Extra map[string]any `json:"extra,omitempty"`
+}
+
+// storePortable writes token to fileName in TokenFormatPortable, its
+// "expires_in" being counted from now.
+func storePortable(fileName string, token *oauth2.Token, now time.Time) error {
+	rec := newTokenRecord(token)
+	p := portableToken{
+		AccessToken:  token.AccessToken,
+		TokenType:    token.TokenType,
+		RefreshToken: token.RefreshToken,
+		Scope:        rec.Scope,
+		IDToken:      rec.IDToken,
+		ClientID:     rec.ClientID,
+		GrantedAt:    rec.GrantedAt,
+		Extra:        rec.Extra,
+	}
+	if !token.Expiry.IsZero() {
+		p.ExpiresIn = max(int64(token.Expiry.Sub(now).Seconds()), 0)
+		p.Expiry = token.Expiry.UTC().Format(time.RFC3339)
+	}
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, b, 0600)
+}
+
+// decodeToken decodes a token in either format, written at written. The
+// expiry of a token holding "expires_in" only, e.g. a portable file written
+// by another tool, is counted from written; such a token is rejected if
+// written is zero.
+func decodeToken(r io.Reader, written time.Time) (*oauth2.Token, error) {
+	var rec tokenRecord
+	if err := json.NewDecoder(r).Decode(&rec); err != nil {
+		return nil, err
+	}
+	token := rec.token()
+	if token.Expiry.IsZero() && token.ExpiresIn > 0 {
+		if written.IsZero() {
+			return nil, errors.New("token has expires_in but no expiry, and the time it was written is unknown")
+		}
+		token.Expiry = written.Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// extraKeys lists the extra fields of a token response preserved by the
+// Manager when it attaches fields of its own with withExtra.
+var extraKeys = []string{"scope", "id_token", "client_id", "granted_at", "extra"}
+
+// withExtraKeys returns token with the fields of Config.ExtraKeys gathered
+// under the "extra" field persisted by the token stores. Fields missing
+// from token, such as those omitted by a refresh response, are taken from
+// prev, if not nil.
+func (m *Manager) withExtraKeys(token, prev *oauth2.Token) *oauth2.Token {
+	if len(m.Config.ExtraKeys) == 0 {
+		return token
+	}
+	kept := make(map[string]any)
+	if prev != nil {
+		if v, ok := prev.Extra("extra").(map[string]any); ok {
+			maps.Copy(kept, v)
+		}
+	}
+	for _, key := range m.Config.ExtraKeys {
+		if v := token.Extra(key); v != nil && !slices.Contains(extraKeys, key) {
+			kept[key] = v
+		}
+	}
+	return withExtra(token, map[string]any{"extra": kept})
+}
+
+// withExtra returns a copy of token whose extra fields are extra merged
+// over the preserved fields of token, including those gathered under
+// "extra" by withExtraKeys.
+func withExtra(token *oauth2.Token, extra map[string]any) *oauth2.Token {
+	merged := make(map[string]any, len(extraKeys)+len(extra))
+	if kept, ok := extra["extra"].(map[string]any); ok {
+		maps.Copy(merged, kept)
+	} else if kept, ok := token.Extra("extra").(map[string]any); ok {
+		maps.Copy(merged, kept)
+	}
+	for _, key := range extraKeys {
+		if v := token.Extra(key); v != nil {
+			merged[key] = v
+		}
+	}
+	maps.Copy(merged, extra)
+	return token.WithExtra(merged)
+}
@@ -0,0 +1,187 @@
+package oauth2kit
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestPruneIntrospectsOwnTokenOnly(t *testing.T) {
+	var introspected []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		introspected = append(introspected, r.Form.Get("token"))
+		fmt.Fprint(w, `{"active":false}`)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	m := &Manager{Config: Config{
+		ClientID:         "client",
+		TokenFile:        path,
+		NamespaceTokens:  true,
+		IntrospectionURL: srv.URL,
+	}}
+	own := m.tokenStore().(*NamespacedFileTokenStore)
+	valid := time.Now().Add(time.Hour)
+	if err := own.Save(ctx, &oauth2.Token{AccessToken: "own", Expiry: valid}); err != nil {
+		t.Fatal(err)
+	}
+	if err := own.ForKey("other").Save(ctx, &oauth2.Token{AccessToken: "other", Expiry: valid}); err != nil {
+		t.Fatal(err)
+	}
+	expired := &oauth2.Token{AccessToken: "expired", Expiry: time.Now().Add(-time.Hour)}
+	if err := own.ForKey("stale").Save(ctx, expired); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := m.Prune(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(introspected, []string{"own"}) {
+		t.Errorf("introspected %v, want only the Manager's own token", introspected)
+	}
+	slices.Sort(removed)
+	if want := slices.Sorted(slices.Values([]string{own.Key, "stale"})); !slices.Equal(removed, want) {
+		t.Errorf("removed %v, want %v", removed, want)
+	}
+	if _, err := own.ForKey("other").Load(ctx); err != nil {
+		t.Errorf("token of another key was removed: %v", err)
+	}
+}
+
+func TestPortableExpiresInFromModTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.json")
+	if err := os.WriteFile(path, []byte(`{"access_token":"access","token_type":"Bearer","expires_in":600}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	written := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, written, written); err != nil {
+		t.Fatal(err)
+	}
+	token, err := (&FileTokenStore{Path: path}).Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := written.Add(10 * time.Minute); !token.Expiry.Equal(want) {
+		t.Errorf("expiry %v, want %v", token.Expiry, want)
+	}
+}
+
+func TestPortableExpiresInFollowsClock(t *testing.T) {
+	clock := newFakeClock()
+	clock.Advance(24 * time.Hour)
+	m := &Manager{Config: Config{
+		TokenFile:   filepath.Join(t.TempDir(), "token.json"),
+		TokenFormat: TokenFormatPortable,
+		Clock:       clock.Now,
+	}}
+	token := &oauth2.Token{AccessToken: "access", Expiry: clock.Now().Add(time.Hour)}
+	if err := m.tokenStore().Save(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(m.Config.TokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p portableToken
+	if err := json.Unmarshal(b, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.ExpiresIn != 3600 {
+		t.Errorf("expires_in %d, want 3600 by Clock", p.ExpiresIn)
+	}
+}
+
+func TestPortableKeepsExtraKeys(t *testing.T) {
+	s := &FileTokenStore{Path: filepath.Join(t.TempDir(), "token.json"), Format: TokenFormatPortable}
+	m := &Manager{Config: Config{ExtraKeys: []string{"tenant"}}}
+	token := m.withExtraKeys((&oauth2.Token{AccessToken: "access"}).WithExtra(map[string]any{"tenant": "acme"}), nil)
+	ctx := context.Background()
+	if err := s.Save(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := s.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.Extra("tenant"); got != "acme" {
+		t.Errorf("tenant %v, want %q", got, "acme")
+	}
+}
+
+func TestStoresKeepExtraFields(t *testing.T) {
+	dir := t.TempDir()
+	m := &Manager{Config: Config{ExtraKeys: []string{"tenant"}}}
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user"}`))
+	idToken := "eyJhbGciOiJub25lIn0." + payload + ".sig"
+	token := m.withExtraKeys((&oauth2.Token{AccessToken: "access"}).WithExtra(map[string]any{
+		"id_token": idToken,
+		"scope":    "read write",
+		"tenant":   "acme",
+	}), nil)
+
+	for name, s := range map[string]TokenStore{
+		"native":     &FileTokenStore{Path: filepath.Join(dir, "native.json")},
+		"portable":   &FileTokenStore{Path: filepath.Join(dir, "portable.json"), Format: TokenFormatPortable},
+		"namespaced": &NamespacedFileTokenStore{Path: filepath.Join(dir, "namespaced.json"), Key: "key"},
+	} {
+		ctx := context.Background()
+		if err := s.Save(ctx, token); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		loaded, err := s.Load(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for _, key := range []string{"id_token", "scope", "tenant"} {
+			if got, want := loaded.Extra(key), token.Extra(key); got != want {
+				t.Errorf("%s: %s %v, want %v", name, key, got, want)
+			}
+		}
+		if claims, err := idTokenClaims(loaded); err != nil || claims["sub"] != "user" {
+			t.Errorf("%s: ID token claims %v, %v", name, claims, err)
+		}
+	}
+}
+
+func TestMigrateStore(t *testing.T) {
+	for _, deleteSource := range []bool{false, true} {
+		dir := t.TempDir()
+		from := &FileTokenStore{Path: filepath.Join(dir, "from.json")}
+		to := &NamespacedFileTokenStore{Path: filepath.Join(dir, "to.json"), Key: "key"}
+		ctx := context.Background()
+		token := (&oauth2.Token{AccessToken: "access", RefreshToken: "refresh"}).WithExtra(map[string]any{"scope": "read"})
+		if err := from.Save(ctx, token); err != nil {
+			t.Fatal(err)
+		}
+
+		m := &Manager{}
+		if err := m.MigrateStore(ctx, from, to, deleteSource); err != nil {
+			t.Fatal(err)
+		}
+		migrated, err := to.Load(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if migrated.RefreshToken != "refresh" || migrated.Extra("scope") != "read" {
+			t.Errorf("migrated token %+v, scope %v", migrated, migrated.Extra("scope"))
+		}
+		if _, err := from.Load(ctx); errors.Is(err, ErrTokenNotFound) != deleteSource {
+			t.Errorf("deleteSource %v: source token load error %v", deleteSource, err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package oauth2kit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// retrieveToken sends a single request with params to the token endpoint,
+// for grants whose handling by golang.org/x/oauth2 cannot be adjusted.
+// Errors reported by the provider are returned as *oauth2.RetrieveError,
+// like golang.org/x/oauth2 does.
+func (m *Manager) retrieveToken(ctx context.Context, params url.Values) (*oauth2.Token, error) {
+	resp, body, err := m.postClientForm(ctx, m.Config.Endpoint.TokenURL, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		AccessToken      string      `json:"access_token"`
+		TokenType        string      `json:"token_type"`
+		RefreshToken     string      `json:"refresh_token"`
+		ExpiresIn        json.Number `json:"expires_in"`
+		Error            string      `json:"error"`
+		ErrorDescription string      `json:"error_description"`
+		ErrorURI         string      `json:"error_uri"`
+	}
+	jsonErr := json.Unmarshal(body, &result)
+	// Some providers report errors with a 200 status
+	if resp.StatusCode < 200 || resp.StatusCode > 299 || result.Error != "" {
+		return nil, &oauth2.RetrieveError{
+			Response:         resp,
+			Body:             body,
+			ErrorCode:        result.Error,
+			ErrorDescription: result.ErrorDescription,
+			ErrorURI:         result.ErrorURI,
+		}
+	}
+	if jsonErr != nil {
+		return nil, fmt.Errorf("decode token response: %w", jsonErr)
+	}
+	if result.AccessToken == "" {
+		return nil, &oauth2.RetrieveError{Response: resp, Body: body, ErrorDescription: "server response missing access_token"}
+	}
+
+	var raw map[string]any
+	_ = json.Unmarshal(body, &raw)
+	token := (&oauth2.Token{
+		AccessToken:  result.AccessToken,
+		TokenType:    result.TokenType,
+		RefreshToken: result.RefreshToken,
+	}).WithExtra(raw)
+	if secs, err := result.ExpiresIn.Int64(); err == nil && secs > 0 {
+		token.ExpiresIn = secs
+		token.Expiry = m.now().Add(time.Duration(secs) * time.Second)
+	}
+	return token, nil
+}
+
+// postClientForm posts params to an endpoint of the provider, authenticating
+// the client as for token requests, and returns the response with its body.
+func (m *Manager) postClientForm(ctx context.Context, endpoint string, params url.Values) (*http.Response, []byte, error) {
+	cfg := m.Config
+	form := url.Values{}
+	for k, v := range params {
+		form[k] = v
+	}
+	secret := cfg.clientSecret()
+	basicAuth := secret != "" && cfg.authStyle() != oauth2.AuthStyleInParams
+	if !basicAuth {
+		form.Set("client_id", cfg.ClientID)
+		if secret != "" {
+			form.Set("client_secret", secret)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basicAuth {
+		req.SetBasicAuth(url.QueryEscape(cfg.ClientID), url.QueryEscape(secret))
+	}
+
+	resp, err := m.httpClient().Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, nil, fmt.Errorf("read response: %w", err)
+	}
+	return resp, body, nil
+}
@@ -0,0 +1,116 @@
+package oauth2kit
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// authTransport authorizes requests with the token of src. Unlike
+// oauth2.Transport, it does not cache the token itself, so that a token
+// replaced by src is used right away, and keeps the token type returned by
+// the provider as is.
+type authTransport struct {
+	src  oauth2.TokenSource
+	base http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.src.Token()
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", authorization(token))
+	return t.base.RoundTrip(r)
+}
+
+// authorization returns the Authorization header value carrying token. The
+// token type is kept as returned by the provider, e.g. "DPoP" or "mac".
+// Tokens without a type are bearer tokens.
+func authorization(token *oauth2.Token) string {
+	return cmp.Or(token.TokenType, "Bearer") + " " + token.AccessToken
+}
+
+// headerTransport adds Config.TokenRequestHeaders to the requests made to
+// the provider's endpoints.
+type headerTransport struct {
+	m    *Manager
+	base http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.m.isEndpointRequest(req) {
+		return t.base.RoundTrip(req)
+	}
+	r := req.Clone(req.Context())
+	for key, values := range t.m.Config.TokenRequestHeaders {
+		r.Header.Del(key)
+		for _, v := range values {
+			r.Header.Add(key, v)
+		}
+	}
+	return t.base.RoundTrip(r)
+}
+
+// exchangeHookTransport lets Config.BeforeExchange adjust the parameters
+// of authorization code exchanges. golang.org/x/oauth2 builds the request
+// itself, so the form is rewritten on its way out; each attempt of a
+// retried exchange goes through the hook again.
+type exchangeHookTransport struct {
+	m    *Manager
+	base http.RoundTripper
+}
+
+func (t *exchangeHookTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.m.isTokenRequest(req) || req.Body == nil {
+		return t.base.RoundTrip(req)
+	}
+	b, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	params, err := url.ParseQuery(string(b))
+	if err == nil && params.Get("grant_type") == "authorization_code" {
+		if err := t.m.Config.BeforeExchange(req.Context(), params); err != nil {
+			return nil, fmt.Errorf("before exchange: %w", err)
+		}
+		b = []byte(params.Encode())
+	}
+	r := req.Clone(req.Context())
+	r.Body = io.NopCloser(bytes.NewReader(b))
+	r.ContentLength = int64(len(b))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(b)), nil
+	}
+	return t.base.RoundTrip(r)
+}
+
+// isEndpointRequest reports whether req is sent to one of the provider's
+// endpoints the Manager posts to.
+func (m *Manager) isEndpointRequest(req *http.Request) bool {
+	cfg := m.Config
+	target := req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+	for _, endpoint := range []string{
+		cfg.Endpoint.TokenURL,
+		cfg.Endpoint.DeviceAuthURL,
+		cfg.IntrospectionURL,
+		cfg.RevocationURL,
+		cfg.PAREndpoint,
+	} {
+		if endpoint, _, _ := strings.Cut(endpoint, "?"); endpoint != "" && endpoint == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package oauth2kit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestAuthorization(t *testing.T) {
+	for typ, want := range map[string]string{
+		"":       "Bearer access",
+		"bearer": "bearer access",
+		"BEARER": "BEARER access",
+		"mac":    "mac access",
+		"MAC":    "MAC access",
+		"DPoP":   "DPoP access",
+	} {
+		token := &oauth2.Token{AccessToken: "access", TokenType: typ}
+		if got := authorization(token); got != want {
+			t.Errorf("token type %q: %q, want %q", typ, got, want)
+		}
+	}
+}
+
+func TestAuthTransportKeepsTokenType(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &authTransport{
+		src:  oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "access", TokenType: "mac"}),
+		base: http.DefaultTransport,
+	}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got != "mac access" {
+		t.Errorf("Authorization %q, want %q", got, "mac access")
+	}
+}